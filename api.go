@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/ini.v1"
+)
+
+var (
+	// how many times a failed api request is retried
+	apiRetries = 3
+
+	// sleep through rate limits instead of failing
+	apiWaitOnRateLimit = false
+
+	// longest rate limit we're willing to sleep through
+	apiMaxRateLimitWait = 15 * time.Minute
+
+	// set while prefetch workers run, which mustn't print: rate limits fail right away instead of being waited
+	// through, and the one-by-one pass after them does the waiting
+	apiNoWait = false
+)
+
+type (
+	// a non-2xx response from an api
+	apiStatusError struct {
+		StatusCode int
+		Message    string
+	}
+
+	// the api refused a request because we're being rate limited
+	rateLimitError struct {
+		Reset      time.Time     // when the limit resets, zero if unknown
+		RetryAfter time.Duration // from Retry-After, zero if not sent
+	}
+)
+
+func (e *apiStatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("api returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("api returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *rateLimitError) Error() string {
+	wait := e.wait()
+	if wait <= 0 {
+		return "api rate limit exceeded"
+	}
+
+	return fmt.Sprintf("api rate limit exceeded, resets at %s (in %s)", time.Now().Add(wait).Format("15:04:05"), wait.Round(time.Second))
+}
+
+// how long until the limit is lifted
+func (e *rateLimitError) wait() time.Duration {
+	if e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+
+	if !e.Reset.IsZero() {
+		return time.Until(e.Reset)
+	}
+
+	return 0
+}
+
+// reads api settings from config.ini
+func loadApiSettings(cfg *ini.File) {
+	sec := cfg.Section("yadeb")
+	apiRetries = sec.Key("ApiRetries").MustInt(3)
+	apiWaitOnRateLimit = sec.Key("WaitOnRateLimit").MustBool(false)
+	apiMaxRateLimitWait = sec.Key("MaxRateLimitWait").MustDuration(15 * time.Minute)
+}
+
+// sends an api request, retrying on network errors, 5xx responses and (if allowed) rate limits
+func apiRequest(req *http.Request) (string, error) {
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		body, err := apiRequestOnce(req)
+		if err == nil {
+			return body, nil
+		}
+
+		if attempt >= apiRetries {
+			return "", err
+		}
+
+		var (
+			rle *rateLimitError
+			se  *apiStatusError
+		)
+
+		switch {
+		case errors.As(err, &rle):
+			wait := rle.wait()
+			if !apiWaitOnRateLimit || apiNoWait || wait <= 0 || wait > apiMaxRateLimitWait {
+				return "", err
+			}
+
+			fmt.Printf("\nRate limited, waiting until %s...", time.Now().Add(wait).Format("15:04:05"))
+			time.Sleep(wait + time.Second)
+		case errors.As(err, &se):
+			if se.StatusCode < 500 {
+				return "", err
+			}
+
+			time.Sleep(backoff)
+			backoff *= 2
+		default:
+			// network error
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// sends an api request once and turns bad responses into errors
+func apiRequestOnce(req *http.Request) (string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return string(body), nil
+	}
+
+	if err := parseRateLimit(resp); err != nil {
+		return "", err
+	}
+
+	return "", &apiStatusError{
+		StatusCode: resp.StatusCode,
+		Message:    gjson.GetBytes(body, "message").String(),
+	}
+}
+
+// returns a rateLimitError if the response says we're rate limited
+func parseRateLimit(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	var e rateLimitError
+
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(s); err == nil {
+			e.RetryAfter = time.Until(t)
+		}
+	}
+
+	if s := resp.Header.Get("X-RateLimit-Reset"); s != "" {
+		if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+			e.Reset = time.Unix(secs, 0)
+		}
+	}
+
+	// a 403 is only a rate limit if the server says so, otherwise it's a permission problem
+	if resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") != "0" && e.RetryAfter == 0 {
+		return nil
+	}
+
+	return &e
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// downloaded .debs, named <sha256>.deb
+	cacheFilesDir = "/var/cache/yadeb/debs"
+
+	// where each cached file came from and when it was last used
+	cacheIndexPath = "/var/cache/yadeb/index.json"
+)
+
+// a cached file
+type cacheEntry struct {
+	Sha256 string
+	Name   string `json:",omitempty"`
+	Url    string `json:",omitempty"`
+
+	// the release it was downloaded for. a url alone doesn't say if the file behind it changed.
+	Tag string `json:",omitempty"`
+
+	Size     int64
+	Added    time.Time
+	LastUsed time.Time
+}
+
+// the download cache. get it with loadCache, change it, then Save it (while holding the lock).
+type downloadCache struct {
+	Entries []cacheEntry `json:"entries"`
+}
+
+// loads the cache index, dropping entries whose file is gone and picking up files it doesn't know about
+func loadCache() (*downloadCache, error) {
+	c := &downloadCache{}
+
+	data, err := os.ReadFile(cacheIndexPath)
+	if err == nil {
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("couldn't parse %s: %s", cacheIndexPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	c.Entries = slices.DeleteFunc(c.Entries, func(e cacheEntry) bool {
+		return !fileExists(cachePath(e.Sha256))
+	})
+
+	// like .debs kept for rollbacks before there was an index
+	files, err := os.ReadDir(cacheFilesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, f := range files {
+		sum, ok := strings.CutSuffix(f.Name(), ".deb")
+		if !ok || c.find(sum) >= 0 {
+			continue
+		}
+
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+
+		c.Entries = append(c.Entries, cacheEntry{Sha256: sum, Size: info.Size(), Added: info.ModTime(), LastUsed: info.ModTime()})
+	}
+
+	return c, nil
+}
+
+// writes the cache index atomically
+func (c *downloadCache) Save() error {
+	if err := os.MkdirAll(cacheFilesDir, 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cacheIndexPath, 0644, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(c)
+	})
+}
+
+// index of the entry with a sha256, -1 if there's none
+func (c *downloadCache) find(sha256sum string) int {
+	return slices.IndexFunc(c.Entries, func(e cacheEntry) bool {
+		return e.Sha256 == sha256sum
+	})
+}
+
+// the cached copy of an asset: by checksum if it has one, otherwise by its url and release. nil if there's none.
+func (c *downloadCache) Lookup(a Asset, tag string) *cacheEntry {
+	for i := range c.Entries {
+		e := &c.Entries[i]
+
+		if a.Checksum != "" {
+			if e.Sha256 == a.Checksum {
+				return e
+			}
+
+			continue
+		}
+
+		if e.Url == a.Url && e.Tag == tag {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// copies a downloaded asset into the cache
+func (c *downloadCache) Add(path string, a Asset, tag string) error {
+	sum, err := fileSha256(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cacheFilesDir, 0755); err != nil {
+		return err
+	}
+
+	if !fileExists(cachePath(sum)) {
+		err := writeFileAtomic(cachePath(sum), 0644, func(w io.Writer) error {
+			in, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+
+			_, err = io.Copy(w, in)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	e := cacheEntry{Sha256: sum, Name: a.Name, Url: a.Url, Tag: tag, Size: info.Size(), Added: now, LastUsed: now}
+
+	// the same file from elsewhere is still the same file
+	if i := c.find(sum); i >= 0 {
+		e.Added = c.Entries[i].Added
+		c.Entries[i] = e
+	} else {
+		c.Entries = append(c.Entries, e)
+	}
+
+	return c.Save()
+}
+
+// deletes the least recently used files until the cache fits [cache] MaxSizeMiB and MaxAge.
+// installed files and the last [yadeb] KeepDebs releases of every package are kept regardless.
+// returns how many files were deleted and how many bytes that freed.
+func (c *downloadCache) Prune(cfg *ini.File) (int, int64, error) {
+	sec := cfg.Section("cache")
+	maxSize := sec.Key("MaxSizeMiB").MustInt64(1024) << 20
+	maxAge := sec.Key("MaxAge").MustDuration(30 * 24 * time.Hour)
+
+	pinned, err := pinnedDebs(cfg.Section("yadeb").Key("KeepDebs").MustInt(0))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	slices.SortFunc(c.Entries, func(a, b cacheEntry) int {
+		return a.LastUsed.Compare(b.LastUsed)
+	})
+
+	var total int64
+	for _, e := range c.Entries {
+		total += e.Size
+	}
+
+	var (
+		kept    []cacheEntry
+		removed int
+		freed   int64
+	)
+
+	// oldest first
+	for _, e := range c.Entries {
+		expired := maxAge > 0 && time.Since(e.LastUsed) > maxAge
+		tooBig := maxSize > 0 && total > maxSize
+
+		if pinned[e.Sha256] || (!expired && !tooBig) {
+			kept = append(kept, e)
+			continue
+		}
+
+		if err := os.Remove(cachePath(e.Sha256)); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+
+		total -= e.Size
+		removed++
+		freed += e.Size
+	}
+
+	c.Entries = kept
+
+	// unfinished downloads nobody came back for
+	if maxAge > 0 {
+		parts, _ := os.ReadDir(partialDir)
+		for _, part := range parts {
+			if info, err := part.Info(); err == nil && time.Since(info.ModTime()) > maxAge {
+				os.Remove(filepath.Join(partialDir, part.Name()))
+			}
+		}
+	}
+
+	return removed, freed, c.Save()
+}
+
+// the sha256s of installed files, and of the last keep releases of every package
+func pinnedDebs(keep int) (map[string]bool, error) {
+	pkgs, err := getAllPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := map[string]bool{}
+	for _, p := range pkgs {
+		for _, a := range p.Assets {
+			pinned[a.Sha256] = true
+		}
+
+		for _, h := range p.History[max(0, len(p.History)-max(keep, 0)):] {
+			for _, a := range h.Assets {
+				pinned[a.Sha256] = true
+			}
+		}
+	}
+
+	return pinned, nil
+}
+
+// where a cached file with a sha256 lives
+func cachePath(sha256sum string) string {
+	return filepath.Join(cacheFilesDir, sha256sum+".deb")
+}
+
+// is [cache] Enabled?
+func cacheEnabled(cfg *ini.File) bool {
+	return cfg.Section("cache").Key("Enabled").MustBool(true)
+}
+
+// the cached copy of an asset, checked against its sha256 and marked as used. "" if there's none.
+func cachedAsset(a Asset, tag string, cfg *ini.File) string {
+	if !cacheEnabled(cfg) {
+		return ""
+	}
+
+	c, err := loadCache()
+	if err != nil {
+		ansiError("Couldn't read the download cache:", err.Error())
+		return ""
+	}
+
+	e := c.Lookup(a, tag)
+	if e == nil {
+		return ""
+	}
+
+	// a damaged copy is as good as none
+	if got, err := fileSha256(cachePath(e.Sha256)); err != nil || got != e.Sha256 {
+		os.Remove(cachePath(e.Sha256))
+		return ""
+	}
+
+	e.LastUsed = time.Now()
+	if err := c.Save(); err != nil {
+		ansiError("Couldn't update the download cache:", err.Error())
+	}
+
+	return cachePath(e.Sha256)
+}
+
+// is there a cached copy of an asset? unlike cachedAsset, this doesn't check or touch it.
+func isCached(a Asset, tag string, cfg *ini.File) bool {
+	if !cacheEnabled(cfg) {
+		return false
+	}
+
+	c, err := loadCache()
+	return err == nil && c.Lookup(a, tag) != nil
+}
+
+// keeps a downloaded and verified asset in the cache, if it's enabled
+func cacheAsset(path string, a Asset, tag string, cfg *ini.File) {
+	if !cacheEnabled(cfg) {
+		return
+	}
+
+	c, err := loadCache()
+	if err == nil {
+		err = c.Add(path, a, tag)
+	}
+
+	if err != nil {
+		ansiError(fmt.Sprintf("Couldn't cache %s:", a.Name), err.Error())
+	}
+}
+
+// prunes the cache after installing, if it's enabled
+func pruneCache(cfg *ini.File) {
+	if !cacheEnabled(cfg) {
+		return
+	}
+
+	c, err := loadCache()
+	if err == nil {
+		_, _, err = c.Prune(cfg)
+	}
+
+	if err != nil {
+		ansiError("Couldn't prune the download cache:", err.Error())
+	}
+}
+
+// the cache command
+func cmdCache(args []string) int {
+	if len(args) != 1 {
+		ansiError("Usage: cache list | cache clean | cache prune")
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		return cacheList()
+	case "clean", "prune":
+		if syscall.Geteuid() != 0 {
+			ansiError("Changing the cache requires root privileges")
+			return 2
+		}
+
+		lock, err := acquireLock()
+		if err != nil {
+			ansiError(err.Error())
+			return 1
+		}
+		defer releaseLock(lock)
+
+		if args[0] == "clean" {
+			return cacheClean()
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			ansiError(err.Error())
+			return 1
+		}
+
+		return cachePrune(cfg)
+	default:
+		ansiError("Unknown cache command:", args[0])
+		return 2
+	}
+}
+
+// lists cached files, most recently used first
+func cacheList() int {
+	c, err := loadCache()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	slices.SortFunc(c.Entries, func(a, b cacheEntry) int {
+		return b.LastUsed.Compare(a.LastUsed)
+	})
+
+	var total int64
+	for _, e := range c.Entries {
+		name := e.Name
+		if name == "" {
+			name = e.Sha256 + ".deb"
+		}
+
+		fmt.Printf("\033[92m%s\033[0m (%s", name, formatSize(e.Size))
+		if e.Tag != "" {
+			fmt.Printf(", release %s", e.Tag)
+		}
+		fmt.Printf(")\nLast used on %s\n", e.LastUsed.Format(time.DateTime))
+		if e.Url != "" {
+			fmt.Println("From", e.Url)
+		}
+		fmt.Printf("sha256: %s\n\n", e.Sha256)
+
+		total += e.Size
+	}
+
+	if len(c.Entries) == 0 {
+		fmt.Println("The cache is empty")
+		return 0
+	}
+
+	fmt.Printf("%d files, %s\n", len(c.Entries), formatSize(total))
+	return 0
+}
+
+// deletes every cached file and unfinished download
+func cacheClean() int {
+	c, err := loadCache()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	var freed int64
+	for _, e := range c.Entries {
+		freed += e.Size
+	}
+
+	fmt.Print("Cleaning the cache...")
+	if err := os.RemoveAll(cacheFilesDir); err != nil {
+		lnAnsiError(err.Error())
+		return 1
+	}
+
+	if err := os.RemoveAll(partialDir); err != nil {
+		lnAnsiError(err.Error())
+		return 1
+	}
+
+	if err := os.Remove(cacheIndexPath); err != nil && !os.IsNotExist(err) {
+		lnAnsiError(err.Error())
+		return 1
+	}
+	fmt.Println(doneMsg)
+
+	printFreed(len(c.Entries), freed)
+	return 0
+}
+
+// applies the cache limits now
+func cachePrune(cfg *ini.File) int {
+	c, err := loadCache()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	fmt.Print("Pruning the cache...")
+	removed, freed, err := c.Prune(cfg)
+	if err != nil {
+		lnAnsiError(err.Error())
+		return 1
+	}
+	fmt.Println(doneMsg)
+
+	printFreed(removed, freed)
+	return 0
+}
+
+// says how much cleaning or pruning got rid of
+func printFreed(removed int, freed int64) {
+	if removed == 0 {
+		fmt.Println("Nothing to delete")
+		return
+	}
+
+	fmt.Printf("Deleted %d files, freed %s\n", removed, formatSize(freed))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// checksum files we understand, besides <file>.sha256 and <file>.sha256sum
+var checksumFileRegex = regexp.MustCompile(`(?i)(^|[._-])(checksums?|sha256sums?)(\.txt)?$`)
+
+// finds c's sha256 in a checksum asset of the same release and stores it in c.Checksum.
+// [yadeb] RequireChecksum decides if not finding one is an error.
+func findChecksum(rel *Release, c *Asset, cfg *ini.File) error {
+	policy := cfg.Section("yadeb").Key("RequireChecksum").In("if-available", []string{"never", "if-available", "always"})
+	if policy == "never" {
+		return nil
+	}
+
+	for _, a := range rel.Assets {
+		// per-file checksums only have a hash in them (and maybe the name)
+		perFile := a.Name == c.Name+".sha256" || a.Name == c.Name+".sha256sum"
+		if !perFile && !checksumFileRegex.MatchString(a.Name) {
+			continue
+		}
+
+		fmt.Printf("Fetching checksums from %s...", a.Name)
+		sum, err := fetchChecksum(a.Url, c.Name, perFile)
+		if err != nil {
+			fmt.Println()
+			return fmt.Errorf("couldn't read %s: %s", a.Name, err)
+		}
+
+		if sum == "" {
+			fmt.Println(" \033[93mNot listed\033[0m")
+			continue
+		}
+		fmt.Println(doneMsg)
+
+		c.Checksum = sum
+		return nil
+	}
+
+	if policy == "always" {
+		return fmt.Errorf("release %s has no checksum for %s, and RequireChecksum is always", rel.Tag, c.Name)
+	}
+
+	fmt.Printf("\033[93mNo checksum available for %s, it won't be verified\033[0m\n", c.Name)
+	return nil
+}
+
+// downloads a checksum file and returns the sha256 listed for name, or "" if it isn't there
+func fetchChecksum(href, name string, perFile bool) (string, error) {
+	req, err := http.NewRequest("GET", href, nil)
+	if err != nil {
+		return "", err
+	}
+	authorizeRequest(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	// checksum files are tiny, anything big is something else
+	return parseChecksums(io.LimitReader(resp.Body, 1<<20), name, perFile)
+}
+
+// reads "<hash>  <file>" lines (sha256sum's format, with or without the binary *), returning name's hash.
+// per-file checksums may be a bare hash.
+func parseChecksums(r io.Reader, name string, perFile bool) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !isSha256(fields[0]) {
+			continue
+		}
+
+		if len(fields) == 1 {
+			if perFile {
+				return strings.ToLower(fields[0]), nil
+			}
+
+			continue
+		}
+
+		file := strings.TrimPrefix(fields[len(fields)-1], "*")
+		file = file[strings.LastIndex(file, "/")+1:]
+
+		if file == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// is s a hex-encoded sha256?
+func isSha256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
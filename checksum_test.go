@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseChecksums(t *testing.T) {
+	const (
+		sumA = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+		sumB = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	)
+
+	tests := []struct {
+		name    string
+		input   string
+		file    string
+		perFile bool
+		want    string
+	}{
+		{"text mode", sumA + "  tool_1.0_amd64.deb\n" + sumB + "  tool_1.0_arm64.deb\n", "tool_1.0_arm64.deb", false, sumB},
+		{"binary mode", sumA + " *tool_1.0_amd64.deb\n", "tool_1.0_amd64.deb", false, sumA},
+		{"with a directory", sumA + "  dist/tool_1.0_amd64.deb\n", "tool_1.0_amd64.deb", false, sumA},
+		{"uppercase", strings.ToUpper(sumA) + "  tool.deb\n", "tool.deb", false, sumA},
+		{"not listed", sumA + "  other.deb\n", "tool.deb", false, ""},
+		{"no partial names", sumA + "  xtool.deb\n", "tool.deb", false, ""},
+		{"junk lines", "# sha256\n\nnot-a-hash tool.deb\n" + sumB + "  tool.deb\n", "tool.deb", false, sumB},
+		{"bare hash, per file", sumA + "\n", "tool.deb", true, sumA},
+		{"bare hash, shared file", sumA + "\n", "tool.deb", false, ""},
+		{"short hash", sumA[:40] + "  tool.deb\n", "tool.deb", false, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := parseChecksums(strings.NewReader(tt.input), tt.file, tt.perFile)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: parseChecksums(..., %q, %v) = %q; want %q", tt.name, tt.file, tt.perFile, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,8 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"strings"
-	"time"
 
 	"gopkg.in/ini.v1"
 )
@@ -54,199 +52,178 @@ func createConfig() error {
 				return err
 			}
 
-			// save ini file
-			if err = cfg.SaveTo("/etc/yadeb/config.ini"); err != nil {
+			// never, if-available or always
+			if _, err = sec.NewKey("RequireChecksum", "if-available"); err != nil {
 				return err
 			}
 
-			if err = os.Chmod("/etc/yadeb/config.ini", 0644); err != nil {
+			if _, err = sec.NewKey("ApiRetries", "3"); err != nil {
 				return err
 			}
-		} else {
-			return err
-		}
-	}
 
-	return nil
-}
+			if _, err = sec.NewKey("WaitOnRateLimit", "false"); err != nil {
+				return err
+			}
 
-// marks a package as installed in /etc/yadeb/installed.ini, creating it if necessary
-func markAsInstalled(debFile, link, installedTag string) error {
-	out, err := exec.Command("dpkg-deb", "--field", debFile, "Package").Output()
-	if err != nil {
-		return err
-	}
-	pkg := strings.TrimSpace(string(out))
+			if _, err = sec.NewKey("MaxRateLimitWait", "15m"); err != nil {
+				return err
+			}
 
-	// get base ini data
-	var cfg *ini.File
-	if _, err := os.Stat("/etc/yadeb/installed.ini"); err != nil {
-		if os.IsNotExist(err) {
-			cfg = ini.Empty()
-		} else {
-			return err
-		}
-	} else {
-		cfg, err = ini.Load("/etc/yadeb/installed.ini")
-		if err != nil {
-			return err
-		}
-	}
+			// downloads: retries (with backoff), how long connecting may take and how long they may stall
+			if _, err = sec.NewKey("DownloadRetries", "5"); err != nil {
+				return err
+			}
 
-	// data
-	sec, err := cfg.NewSection(link)
-	if err != nil {
-		return err
-	}
+			if _, err = sec.NewKey("ConnectTimeout", "30s"); err != nil {
+				return err
+			}
 
-	// I LOVE GO!!!
-	// do i REALLY need a /s there?
+			if _, err = sec.NewKey("ReadTimeout", "60s"); err != nil {
+				return err
+			}
 
-	if _, err = sec.NewKey("Package", pkg); err != nil {
-		return err
-	}
+			// how many packages are checked for releases, and how many files downloaded, at once
+			if _, err = sec.NewKey("Concurrency", "4"); err != nil {
+				return err
+			}
 
-	if _, err = sec.NewKey("InstalledTag", installedTag); err != nil {
-		return err
-	}
+			// regexes (comma separated, in order) that pick a package file when nobody can be asked
+			if _, err = sec.NewKey("AssetPreference", ""); err != nil {
+				return err
+			}
 
-	if _, err = sec.NewKey("InstallDate", time.Now().Format("2006-01-02")); err != nil {
-		return err
-	}
+			// how many previously installed releases per package keep their .debs in the cache, whatever its limits
+			if _, err = sec.NewKey("KeepDebs", "0"); err != nil {
+				return err
+			}
 
-	if _, err = sec.NewKey("LastUpdate", time.Now().Format("2006-01-02")); err != nil {
-		return err
-	}
+			// downloaded .debs, kept in /var/cache/yadeb until they're too old or the cache is too big (0 for no limit)
+			sec, err = cfg.NewSection("cache")
+			if err != nil {
+				return err
+			}
 
-	// save ini file
-	if err = cfg.SaveTo("/etc/yadeb/installed.ini"); err != nil {
-		return err
-	}
+			if _, err = sec.NewKey("Enabled", "true"); err != nil {
+				return err
+			}
 
-	if err = os.Chmod("/etc/yadeb/installed.ini", 0644); err != nil {
-		return err
-	}
+			if _, err = sec.NewKey("MaxSizeMiB", "1024"); err != nil {
+				return err
+			}
 
-	return nil
-}
+			if _, err = sec.NewKey("MaxAge", "720h"); err != nil {
+				return err
+			}
 
-// unmarks a package as installed
-func unmarkAsInstalled(link string) error {
-	// file not exist logic
-	if _, err := os.Stat("/etc/yadeb/installed.ini"); err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		} else {
-			return err
-		}
-	}
+			// github auth. Token only works if config.ini isn't world-readable.
+			sec, err = cfg.NewSection("github")
+			if err != nil {
+				return err
+			}
 
-	// load
-	cfg, err := ini.Load("/etc/yadeb/installed.ini")
-	if err != nil {
-		return err
-	}
+			if _, err = sec.NewKey("Token", ""); err != nil {
+				return err
+			}
 
-	// remove
-	cfg.DeleteSection(link)
+			if _, err = sec.NewKey("TokenFile", "/etc/yadeb/github-token"); err != nil {
+				return err
+			}
 
-	// save
-	if err = cfg.SaveTo("/etc/yadeb/installed.ini"); err != nil {
-		return err
-	}
+			// self-hosted gitlab instances (comma separated) and gitlab.com auth.
+			// other hosts' tokens go in their own sections, like [gitlab "gitlab.example.com"] with Token or TokenFile.
+			sec, err = cfg.NewSection("gitlab")
+			if err != nil {
+				return err
+			}
 
-	return nil
-}
+			if _, err = sec.NewKey("Hosts", ""); err != nil {
+				return err
+			}
 
-// updates a package's install mark
-func updatePackageMark(link, tag string) error {
-	// file not exist logic
-	if _, err := os.Stat("/etc/yadeb/installed.ini"); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("install database doesn't exist")
-		} else {
-			return err
-		}
-	}
+			if _, err = sec.NewKey("Token", ""); err != nil {
+				return err
+			}
 
-	// load
-	cfg, err := ini.Load("/etc/yadeb/installed.ini")
-	if err != nil {
-		return err
-	}
+			if _, err = sec.NewKey("TokenFile", "/etc/yadeb/gitlab-token"); err != nil {
+				return err
+			}
 
-	// update
-	for _, sec := range cfg.Sections() {
-		if sec.Name() == link {
-			sec.Key("InstalledTag").SetValue(tag)
-			sec.Key("LastUpdate").SetValue(time.Now().Format("2006-01-02"))
-		}
-	}
+			// gitea/forgejo instances other than codeberg.org (comma separated) and codeberg.org auth.
+			// other hosts' tokens go in their own sections, like [gitea "git.example.com"] with Token or TokenFile.
+			sec, err = cfg.NewSection("gitea")
+			if err != nil {
+				return err
+			}
 
-	// save
-	if err = cfg.SaveTo("/etc/yadeb/installed.ini"); err != nil {
-		return err
-	}
+			if _, err = sec.NewKey("Hosts", ""); err != nil {
+				return err
+			}
 
-	return nil
-}
+			if _, err = sec.NewKey("Token", ""); err != nil {
+				return err
+			}
 
-// gets a tracked package by link
-func getPackage(link string) (*Package, error) {
-	if _, err := os.Stat("/etc/yadeb/installed.ini"); err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		} else {
-			return nil, err
-		}
-	}
+			if _, err = sec.NewKey("TokenFile", "/etc/yadeb/gitea-token"); err != nil {
+				return err
+			}
 
-	cfg, err := ini.Load("/etc/yadeb/installed.ini")
-	if err != nil {
-		return nil, err
-	}
+			// offline sigstore verification: fulcio root/intermediate certs and rekor's public key
+			sec, err = cfg.NewSection("sigstore")
+			if err != nil {
+				return err
+			}
 
-	for _, section := range cfg.Sections() {
-		if section.Name() == link {
-			var p Package
-			p.Link = section.Name()
-			if err = section.MapTo(&p); err != nil {
-				return nil, err
+			if _, err = sec.NewKey("TrustedRoot", "/etc/yadeb/sigstore/fulcio.pem"); err != nil {
+				return err
 			}
 
-			return &p, nil
+			if _, err = sec.NewKey("RekorKey", "/etc/yadeb/sigstore/rekor.pub"); err != nil {
+				return err
+			}
+
+			// false accepts bundles without a checkable transparency log entry (or without rekor's key),
+			// but then a certificate's expiry can't be enforced
+			if _, err = sec.NewKey("RequireTlog", "true"); err != nil {
+				return err
+			}
+
+			// save ini file
+			if err = saveIni(cfg, "/etc/yadeb/config.ini"); err != nil {
+				return err
+			}
+		} else {
+			return err
 		}
 	}
 
-	return nil, nil
+	return nil
 }
 
-// gets all tracked packages
-func getAllPackages() ([]Package, error) {
-	if _, err := os.Stat("/etc/yadeb/installed.ini"); err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		} else {
-			return nil, err
-		}
+// atomically saves an ini file as 0644
+func saveIni(cfg *ini.File, path string) error {
+	return writeFileAtomic(path, 0644, func(w io.Writer) error {
+		_, err := cfg.WriteTo(w)
+		return err
+	})
+}
+
+// creates (if needed), reads and applies /etc/yadeb/config.ini
+func loadConfig() (*ini.File, error) {
+	if err := createConfigDir(); err != nil {
+		return nil, fmt.Errorf("couldn't create (or check existence of) /etc/yadeb: %s", err)
 	}
 
-	cfg, err := ini.Load("/etc/yadeb/installed.ini")
+	cfg, err := ini.Load("/etc/yadeb/config.ini")
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("couldn't read /etc/yadeb/config.ini: %s", err)
 	}
 
-	var pkgs []Package
-
-	for _, section := range cfg.Sections() {
-		var p Package
-		p.Link = section.Name()
-		if err = section.MapTo(&p); err != nil {
-			return nil, err
-		}
+	loadApiSettings(cfg)
+	loadDownloadSettings(cfg)
 
-		pkgs = append(pkgs, p)
+	if err := configureSources(cfg); err != nil {
+		return nil, err
 	}
 
-	return pkgs, nil
+	return cfg, nil
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// a .deb starts with "!<arch>\n", a 60 byte member header and debian-binary ("2.0\n"), then control.tar's header
+	debHeaderSize = 8 + 60 + 4 + 60
+
+	// control.tar is a few KB, anything bigger isn't worth fetching just to pick a file
+	maxControlSize = 1 << 20
+)
+
+// what a .deb says about itself
+type debControl struct {
+	Package      string
+	Version      string
+	Architecture string
+}
+
+// reads the control fields of a remote .deb with range requests, without downloading the rest
+func fetchDebControl(a Asset) (*debControl, error) {
+	if r, ok := prefetchedControlOf(a.Url); ok {
+		return r.ctl, r.err
+	}
+
+	header, err := fetchRange(a, 0, debHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(header, []byte("!<arch>\n")) {
+		return nil, fmt.Errorf("not a .deb (no ar header)")
+	}
+
+	if name := strings.TrimSpace(string(header[8:24])); name != "debian-binary" && name != "debian-binary/" {
+		return nil, fmt.Errorf("not a .deb (first member is %s)", name)
+	}
+
+	if strings.TrimSpace(string(header[56:66])) != "4" {
+		return nil, fmt.Errorf("unsupported .deb format version")
+	}
+
+	// control member header
+	member := header[72:]
+	if string(member[58:60]) != "`\n" {
+		return nil, fmt.Errorf("bad ar member header")
+	}
+
+	name := strings.TrimSuffix(strings.TrimSpace(string(member[:16])), "/")
+	if !strings.HasPrefix(name, "control.tar") {
+		return nil, fmt.Errorf("second member is %s, not control.tar", name)
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(member[48:58])), 10, 64)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("bad control.tar size")
+	}
+
+	if size > maxControlSize || (a.Size > 0 && debHeaderSize+size > a.Size) {
+		return nil, fmt.Errorf("control.tar is %d bytes, refusing to fetch it", size)
+	}
+
+	data, err := fetchRange(a, debHeaderSize, size)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompressor(name, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %s", name, err)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s has no control file", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read %s: %s", name, err)
+		}
+
+		if path.Clean(h.Name) == "control" {
+			return parseControl(io.LimitReader(tr, maxControlSize))
+		}
+	}
+}
+
+// gets n bytes at off. servers that ignore Range only get read up to what's needed, and only if
+// the asset isn't huge (otherwise the connection would keep pushing the whole file).
+func fetchRange(a Asset, off, n int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", a.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorizeRequest(req)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// a.Size is 0 when it's unknown
+		size := max(a.Size, resp.ContentLength)
+		if (a.Size <= 0 && resp.ContentLength < 0) || size > 4*maxControlSize {
+			return nil, fmt.Errorf("server doesn't support range requests")
+		}
+
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return nil, fmt.Errorf("file is too short: %s", err)
+	}
+
+	return buf, nil
+}
+
+// picks a decompressor by control.tar's extension
+func decompressor(name string, r io.Reader) (io.Reader, error) {
+	switch path.Ext(name) {
+	case ".tar":
+		return r, nil
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".xz":
+		return xz.NewReader(r)
+	case ".zst":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression")
+	}
+}
+
+// reads the fields we care about from a control file. continuation lines are skipped, none of them span lines.
+func parseControl(r io.Reader) (*debControl, error) {
+	var c debControl
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.HasPrefix(key, " ") || strings.HasPrefix(key, "\t") {
+			continue
+		}
+
+		switch key {
+		case "Package":
+			c.Package = strings.TrimSpace(value)
+		case "Version":
+			c.Version = strings.TrimSpace(value)
+		case "Architecture":
+			c.Architecture = strings.TrimSpace(value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if c.Package == "" || c.Architecture == "" {
+		return nil, fmt.Errorf("control file is missing Package or Architecture")
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// what /etc/os-release says we're running
+type osRelease struct {
+	ID        string   // like debian or ubuntu
+	IDLike    []string // distros this one is based on
+	VersionID string   // like 12 or 22.04
+	Codename  string   // like bookworm or jammy
+}
+
+var (
+	// distro ids, with the short forms people put in file names. see distroTightNames for deb.
+	distroNames = map[string][]string{
+		"debian":     {"debian", "deb"},
+		"ubuntu":     {"ubuntu"},
+		"linuxmint":  {"linuxmint", "mint"},
+		"pop":        {"pop", "popos"},
+		"raspbian":   {"raspbian"},
+		"kali":       {"kali"},
+		"elementary": {"elementary"},
+	}
+
+	// release codenames, which are also how some projects tag builds
+	distroCodenames = []string{
+		"buster", "bullseye", "bookworm", "trixie", "forky", "sid",
+		"bionic", "focal", "jammy", "kinetic", "lunar", "mantic", "noble", "oracular", "plucky", "questing",
+	}
+
+	// short forms that are only a distro right before the version (deb12), since tool-deb-2.0 is just a name
+	distroTightNames = []string{"deb"}
+
+	// a distro tag in a file name: a name with a version (ubuntu22.04, debian-12, deb12), or a codename
+	distroTagRegex = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])((?:(?:` + strings.Join(allDistroNames(), "|") + `)[-_.]?|(?:` + strings.Join(distroTightNames, "|") + `))[0-9]+(?:\.[0-9]+)*|(?:` + strings.Join(distroCodenames, "|") + `))(?:[^a-z0-9]|$)`)
+
+	// /etc/os-release, once read
+	hostOsRelease *osRelease
+)
+
+// every distro name and short form, except distroTightNames
+func allDistroNames() []string {
+	var names []string
+	for _, v := range distroNames {
+		for _, name := range v {
+			if !slices.Contains(distroTightNames, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	// longest first, so pop doesn't win over popos
+	slices.SortFunc(names, func(a, b string) int {
+		return len(b) - len(a)
+	})
+
+	return names
+}
+
+// reads /etc/os-release (or /usr/lib/os-release). an unknown system is an empty osRelease.
+func hostDistro() *osRelease {
+	if hostOsRelease != nil {
+		return hostOsRelease
+	}
+
+	hostOsRelease = &osRelease{}
+
+	cfg, err := ini.Load("/etc/os-release")
+	if err != nil {
+		if cfg, err = ini.Load("/usr/lib/os-release"); err != nil {
+			return hostOsRelease
+		}
+	}
+
+	section := cfg.Section(ini.DefaultSection)
+	hostOsRelease.ID = strings.ToLower(section.Key("ID").String())
+	hostOsRelease.IDLike = strings.Fields(strings.ToLower(section.Key("ID_LIKE").String()))
+	hostOsRelease.VersionID = section.Key("VERSION_ID").String()
+	hostOsRelease.Codename = strings.ToLower(section.Key("VERSION_CODENAME").String())
+
+	return hostOsRelease
+}
+
+// the distro tag in a file name (like ubuntu22.04 or bookworm), lowercase. "" for generic builds.
+func distroTag(name string) string {
+	m := distroTagRegex.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+
+	return strings.ToLower(m[1])
+}
+
+// how well a distro tag fits the system: 3 for this exact release, 2 for this distro, 1 for generic builds,
+// 0 for a distro it's based on and -1 for anything else
+func distroScore(tag string, host *osRelease) int {
+	if tag == "" {
+		return 1
+	}
+
+	if host.Codename != "" && tag == host.Codename {
+		return 3
+	}
+
+	// split ubuntu-22.04 into ubuntu and 22.04
+	name := strings.TrimRight(tag, "0123456789.")
+	version := strings.TrimPrefix(tag, name)
+	name = strings.TrimRight(name, "-_.")
+
+	if version == "" {
+		// a codename that isn't ours
+		return -1
+	}
+
+	matches := func(id string) bool {
+		return slices.Contains(distroNames[id], name)
+	}
+
+	if matches(host.ID) {
+		// 22.04 and 2204 are the same thing, 12 and 12.5 too
+		v := strings.ReplaceAll(version, ".", "")
+		if v == strings.ReplaceAll(host.VersionID, ".", "") || strings.HasPrefix(version, host.VersionID+".") {
+			return 3
+		}
+
+		return 2
+	}
+
+	if slices.ContainsFunc(host.IDLike, matches) {
+		return 0
+	}
+
+	return -1
+}
+
+// keeps the candidates built for this distro: the remembered variant if there is one, otherwise the best scoring ones.
+// builds for other distros are only dropped when there's something better.
+func filterDistro(candidates []Asset, remembered string) []Asset {
+	if !slices.ContainsFunc(candidates, func(a Asset) bool { return distroTag(a.Name) != "" }) {
+		return candidates
+	}
+
+	if remembered != "" {
+		same := slices.DeleteFunc(slices.Clone(candidates), func(a Asset) bool {
+			return distroTag(a.Name) != remembered
+		})
+
+		if len(same) != 0 {
+			return same
+		}
+
+		fmt.Printf("\033[93mNo %s build in this release, picking another one\033[0m\n", remembered)
+	}
+
+	host := hostDistro()
+	best := -1
+	for _, a := range candidates {
+		best = max(best, distroScore(distroTag(a.Name), host))
+	}
+
+	// nothing fits, let the user decide
+	if best < 0 {
+		return candidates
+	}
+
+	return slices.DeleteFunc(slices.Clone(candidates), func(a Asset) bool {
+		return distroScore(distroTag(a.Name), host) != best
+	})
+}
+
+// the distro variant of a set of assets, to remember for upgrades
+func assetsDistro(assets []Asset) string {
+	for _, a := range assets {
+		if tag := distroTag(a.Name); tag != "" {
+			return tag
+		}
+	}
+
+	return ""
+}
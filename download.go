@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// unfinished downloads, named by the sha256 of their url, so a later run can pick up where this one stopped
+const partialDir = "/var/cache/yadeb/partial"
+
+var (
+	// how many times a failed download is retried
+	downloadRetries = 5
+
+	// how long connecting (and the tls handshake) may take
+	downloadConnectTimeout = 30 * time.Second
+
+	// how long a download may go without receiving anything
+	downloadReadTimeout = time.Minute
+
+	downloadClient = newDownloadClient()
+)
+
+// a non-2xx response to a download
+type downloadStatusError struct {
+	StatusCode int
+}
+
+func (e *downloadStatusError) Error() string {
+	return fmt.Sprintf("server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// is it worth asking again?
+func (e *downloadStatusError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// reads download settings from config.ini
+func loadDownloadSettings(cfg *ini.File) {
+	sec := cfg.Section("yadeb")
+	downloadRetries = sec.Key("DownloadRetries").MustInt(5)
+	downloadConnectTimeout = sec.Key("ConnectTimeout").MustDuration(30 * time.Second)
+	downloadReadTimeout = sec.Key("ReadTimeout").MustDuration(time.Minute)
+	downloadClient = newDownloadClient()
+}
+
+// an http client with the connect and read timeouts
+func newDownloadClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: downloadConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = downloadConnectTimeout
+	t.ResponseHeaderTimeout = downloadReadTimeout
+
+	return &http.Client{Transport: t}
+}
+
+// downloads a file, resuming unfinished downloads and retrying on network errors and 5xx responses.
+// if sha256sum isn't empty, the download has to match it.
+func downloadFile(href, path, sha256sum string) error {
+	part := partialPath(href, path)
+
+	// prefetchDownloads may have done the work (or failed at it, retries and all) already
+	got, done, err := takePrefetchedDownload(href)
+	if !done {
+		got, err = downloadPart(href, part, true)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// a bad file won't get better by resuming it
+	if sha256sum != "" && got != sha256sum {
+		removePartial(part)
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", sha256sum, got)
+	}
+
+	os.Remove(part + ".validator")
+	return moveFile(part, path)
+}
+
+// downloads href into part, retrying with exponential backoff. show is for the progress bar and retry messages.
+// returns the sha256 of the finished file.
+func downloadPart(href, part string, show bool) (string, error) {
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		sum, err := downloadOnce(href, part, show)
+		if err == nil {
+			return sum, nil
+		}
+
+		var se *downloadStatusError
+		if attempt >= downloadRetries || (errors.As(err, &se) && !se.retryable()) {
+			return "", err
+		}
+
+		if show {
+			fmt.Printf("\n  Download failed (%s), retrying in %s...", err, backoff)
+		}
+
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// where a download is kept until it's complete: in partialDir if we can write there, otherwise next to path
+func partialPath(href, path string) string {
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		return path + ".part"
+	}
+
+	sum := sha256.Sum256([]byte(href))
+	return filepath.Join(partialDir, hex.EncodeToString(sum[:])+".part")
+}
+
+// deletes an unfinished download
+func removePartial(part string) {
+	os.Remove(part)
+	os.Remove(part + ".validator")
+}
+
+// downloads href into part, continuing where part ends if the server allows it. returns the sha256 of the whole file,
+// hashed as it's written.
+func downloadOnce(href, part string, show bool) (string, error) {
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	// the validator (etag or last-modified) of the first response makes sure we're still resuming the same file.
+	// without one there's no telling, so the download starts over.
+	validator, _ := os.ReadFile(part + ".validator")
+	if offset > 0 && len(validator) == 0 {
+		if err := f.Truncate(0); err != nil {
+			return "", err
+		}
+
+		if offset, err = f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+
+	// what's already there is part of the checksum too
+	hash := sha256.New()
+	if offset > 0 {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+
+		if _, err := io.CopyN(hash, f, offset); err != nil {
+			return "", err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", href, nil)
+	if err != nil {
+		return "", err
+	}
+	authorizeRequest(req)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(validator))
+	}
+
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		// servers that ignore If-Range would splice a changed file onto the old one
+		if responseValidator(resp) != string(validator) {
+			removePartial(part)
+			return "", fmt.Errorf("file changed on the server, starting over")
+		}
+
+		if start, _, _ := strings.Cut(strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes "), "-"); start != strconv.FormatInt(offset, 10) {
+			removePartial(part)
+			return "", fmt.Errorf("server resumed at the wrong place")
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// nothing left to download, unless the file got smaller
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return hex.EncodeToString(hash.Sum(nil)), nil
+		}
+
+		removePartial(part)
+		return "", fmt.Errorf("server couldn't resume the download")
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// the whole file, from the start
+		if err := f.Truncate(0); err != nil {
+			return "", err
+		}
+
+		if offset, err = f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		hash.Reset()
+
+		if err := os.WriteFile(part+".validator", []byte(responseValidator(resp)), 0644); err != nil {
+			return "", err
+		}
+	default:
+		return "", &downloadStatusError{resp.StatusCode}
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	// give up on connections that stall
+	idle := time.AfterFunc(downloadReadTimeout, cancel)
+	defer idle.Stop()
+
+	prog := newProgress(offset, total, show)
+	defer prog.finish()
+
+	// hashing as we go
+	out := io.MultiWriter(f, hash)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			idle.Reset(downloadReadTimeout)
+
+			if _, err := out.Write(buf[:n]); err != nil {
+				return "", err
+			}
+			prog.add(n)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("nothing received for %s", downloadReadTimeout)
+			}
+
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), f.Close()
+}
+
+// what tells versions of a file apart: its etag, or its last-modified date if it has none
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag
+	}
+
+	return resp.Header.Get("Last-Modified")
+}
+
+// shows how a download is going: a bar with rate and eta on terminals, a plain line every few seconds otherwise
+type progress struct {
+	done  int64
+	total int64 // -1 if unknown
+
+	start     time.Time
+	startDone int64 // what was there before, which doesn't count towards the rate
+	lastDraw  time.Time
+	show      bool
+	tty       bool
+}
+
+// starts showing progress, if show is set. on terminals the bar goes right after what's already on the line.
+func newProgress(done, total int64, show bool) *progress {
+	p := &progress{done: done, total: total, start: time.Now(), startDone: done, lastDraw: time.Now(), show: show, tty: show && stdoutIsTerminal()}
+	if p.tty {
+		fmt.Print("\0337") // save the cursor
+	}
+
+	return p
+}
+
+// counts n more bytes, redrawing at most 5 times a second (or every 5 seconds without a terminal)
+func (p *progress) add(n int) {
+	p.done += int64(n)
+
+	if p.tty {
+		if time.Since(p.lastDraw) >= 200*time.Millisecond {
+			p.lastDraw = time.Now()
+			fmt.Print("\0338\033[K", p.line())
+		}
+
+		return
+	}
+
+	if p.show && time.Since(p.lastDraw) >= 5*time.Second {
+		p.lastDraw = time.Now()
+		fmt.Print("\n  ", p.line())
+	}
+}
+
+// clears the bar, so what comes next goes where it started
+func (p *progress) finish() {
+	if p.tty {
+		fmt.Print("\0338\033[K")
+	}
+}
+
+// like [=========>          ]  45% 112.0 MiB of 250.0 MiB, 3.2 MiB/s, 1m12s left
+func (p *progress) line() string {
+	var s string
+	if p.total > 0 {
+		pct := min(p.done*100/p.total, 100)
+		if p.tty {
+			filled := int(pct / 5)
+			s = "[" + strings.Repeat("=", filled) + strings.Repeat(" ", 20-filled) + "] "
+		}
+		s += fmt.Sprintf("%3d%% %s of %s", pct, formatSize(p.done), formatSize(p.total))
+	} else {
+		s = formatSize(p.done)
+	}
+
+	rate := float64(p.done-p.startDone) / time.Since(p.start).Seconds()
+	if rate >= 1 {
+		s += fmt.Sprintf(", %s/s", formatSize(int64(rate)))
+
+		if p.total > 0 {
+			left := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+			s += fmt.Sprintf(", %s left", left.Round(time.Second))
+		}
+	}
+
+	return s
+}
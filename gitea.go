@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/ini.v1"
+)
+
+// gitea/forgejo releases, including codeberg.org
+type giteaSource struct {
+	tokens map[string]string // by host. hosts without one are anonymous.
+}
+
+func init() {
+	registerSource(&giteaSource{}, "codeberg.org")
+}
+
+// reads extra gitea hosts and their tokens from config.ini, $GITEA_TOKEN or the token files
+func (s *giteaSource) Configure(cfg *ini.File) error {
+	var hosts []string
+	for _, h := range cfg.Section("gitea").Key("Hosts").Strings(",") {
+		if h != "" {
+			addSourceKeys(s, h)
+			hosts = append(hosts, h)
+		}
+	}
+
+	tokens, err := readHostTokens(cfg, "gitea", "GITEA_TOKEN", "codeberg.org", hosts)
+	if err != nil {
+		return fmt.Errorf("gitea token: %s", err)
+	}
+
+	s.tokens = tokens
+	return nil
+}
+
+// host/owner/repo, without anything after the repo
+func (s *giteaSource) Normalize(u *url.URL) (*url.URL, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("gitea links should look like %s/owner/repo", u.Host)
+	}
+
+	return &url.URL{Scheme: "https", Host: u.Host, Path: "/" + parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")}, nil
+}
+
+// owner/repo
+func (s *giteaSource) PackageName(u *url.URL) string {
+	return strings.Trim(u.Path, "/")
+}
+
+// uses gitea api to get a repo's releases. drafts are left out.
+func (s *giteaSource) Releases(u *url.URL, depth int) ([]Release, error) {
+	json, err := s.apiRequest(u.Host, fmt.Sprintf("repos/%s/releases?draft=false&limit=%d", s.PackageName(u), depth))
+	if err != nil {
+		return nil, err
+	}
+
+	if !gjson.Parse(json).IsArray() {
+		return nil, fmt.Errorf("unexpected response from %s", u.Host)
+	}
+
+	// the release json is shaped like github's
+	var releases []Release
+	for _, r := range gjson.Parse(json).Array() {
+		releases = append(releases, githubParseRelease(r))
+	}
+
+	return releases, nil
+}
+
+// gets a release by tag
+func (s *giteaSource) ReleaseByTag(u *url.URL, tag string) (*Release, error) {
+	json, err := s.apiRequest(u.Host, fmt.Sprintf("repos/%s/releases/tags/%s", s.PackageName(u), url.PathEscape(tag)))
+	if err != nil {
+		return nil, err
+	}
+
+	rel := githubParseRelease(gjson.Parse(json))
+	return &rel, nil
+}
+
+// sets the token of the request's host on it, if it has one
+func (s *giteaSource) Authorize(req *http.Request) {
+	if t := s.tokens[req.URL.Host]; t != "" {
+		req.Header.Set("Authorization", "token "+t)
+	}
+}
+
+// sends a request to a gitea instance's api
+func (s *giteaSource) apiRequest(host, path string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/api/v1/%s", host, path), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	s.Authorize(req)
+
+	return apiRequest(req)
+}
@@ -1,153 +1,126 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 
 	"github.com/tidwall/gjson"
 	"gopkg.in/ini.v1"
 )
 
-// github-specific candidate collection
-func githubGetCandidates(u *url.URL, tagFlag string, cfg *ini.File) ([]string, string, string, error) {
-	// get user and repo
-	pkgName, _ := strings.CutPrefix(u.Path, "/")
-
-	var (
-		candidates []string
-		tag        string
-	)
-
-	// go through releases
-	if tagFlag == "latest" {
-		// get releases
-		fmt.Printf("Fetching releases from github.com/%s...", pkgName)
-		releaseJson, err := githubGetReleases(pkgName, cfg.Section("yadeb").Key("ReleaseDepth").MustInt(50))
-		if err != nil {
-			fmt.Println() // Yes, this is bad. Yes, you will see this a lot.
-			return nil, "", "", fmt.Errorf("couldn't fetch github releases: %s", err)
-		}
-		fmt.Println(doneMsg)
-
-		if gjson.Get(releaseJson, "#").Int() == 0 {
-			return nil, "", "", fmt.Errorf("requested package has no releases available")
-		}
-
-		tag, candidates, err = githubFindLatestValidRelease(releaseJson, cfg)
-		if err != nil {
-			return nil, "", "", err
-		}
-	} else {
-		fmt.Printf("Fetching github.com/%s at release %s...", pkgName, tagFlag)
-		releaseJson, err := githubReleaseByTag(pkgName, tagFlag)
-		if err != nil {
-			fmt.Println()
-			return nil, "", "", fmt.Errorf("release %s: failed to fetch: %s", tagFlag, err.Error())
-		}
-		fmt.Println(doneMsg)
-
-		if gjson.Get(releaseJson, "status").String() == "404" {
-			return nil, "", "", fmt.Errorf("release %s: not found", tagFlag)
-		}
-
-		tag = tagFlag
-
-		candidates, err = githubFormatCandidates(releaseJson, "assets")
-		if err != nil {
-			return nil, "", "", fmt.Errorf("release %s: %s", tag, err.Error())
-		}
-	}
+// github.com releases
+type githubSource struct {
+	token string // empty if requests should be anonymous
+}
 
-	return candidates, pkgName, tag, nil
+func init() {
+	registerSource(&githubSource{}, "github.com")
 }
 
-func githubApiRequest(link string) (string, error) {
-	req, err := http.NewRequest("GET", link, nil)
+// finds a github token in $GITHUB_TOKEN, $GH_TOKEN, config.ini or the token file, in that order
+func (s *githubSource) Configure(cfg *ini.File) error {
+	t, err := readToken(cfg.Section("github"), firstNonEmpty(os.Getenv("GITHUB_TOKEN"), os.Getenv("GH_TOKEN")), "/etc/yadeb/github-token")
 	if err != nil {
-		return "", err
+		return fmt.Errorf("github token: %s", err)
 	}
 
-	// set headers
-	req.Header.Set("Accept", "application/vnd.github+json")
-	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+	s.token = t
+	return nil
+}
 
-	// read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+// github.com/owner/repo, without anything after the repo
+func (s *githubSource) Normalize(u *url.URL) (*url.URL, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("github links should look like github.com/owner/repo")
 	}
 
-	return string(body), nil
+	return &url.URL{Scheme: "https", Host: "github.com", Path: "/" + parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")}, nil
 }
 
-// uses github api to get repo's releases
-func githubGetReleases(pkgName string, releaseDepth int) (string, error) {
-	return githubApiRequest(fmt.Sprintf("https://api.github.com/repos/%s/releases?per_page=%d", pkgName, releaseDepth))
+// owner/repo
+func (s *githubSource) PackageName(u *url.URL) string {
+	return strings.Trim(u.Path, "/")
 }
 
-// gets a tag
-func githubReleaseByTag(pkgName, tag string) (string, error) {
-	return githubApiRequest(fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", pkgName, tag))
-}
+// uses github api to get repo's releases
+func (s *githubSource) Releases(u *url.URL, depth int) ([]Release, error) {
+	json, err := s.apiRequest(fmt.Sprintf("https://api.github.com/repos/%s/releases?per_page=%d", s.PackageName(u), depth))
+	if err != nil {
+		return nil, err
+	}
 
-func githubFindLatestValidRelease(json string, cfg *ini.File) (string, []string, error) {
-	for i := range gjson.Get(json, "#").Int() {
-		// get tag
-		tag := gjson.Get(json, fmt.Sprintf("%d.tag_name", i)).String()
+	// anything other than a list is an error we don't understand
+	if !gjson.Parse(json).IsArray() {
+		return nil, fmt.Errorf("unexpected response from github")
+	}
 
-		if !cfg.Section("yadeb").Key("AllowPrerelease").MustBool(false) && gjson.Get(json, fmt.Sprintf("%d.prerelease", i)).Bool() {
-			fmt.Printf("Skipping release %s: \033[91mrelease is a prerelease, which is disallowed\033[0m\n", tag)
-			continue
-		}
+	var releases []Release
+	for _, r := range gjson.Parse(json).Array() {
+		releases = append(releases, githubParseRelease(r))
+	}
 
-		candidates, err := githubFormatCandidates(json, fmt.Sprintf("%d.assets", i))
-		if err != nil {
-			fmt.Printf("Skipping release %s: \033[91m%s\033[0m\n", tag, err.Error())
-			continue
-		}
+	return releases, nil
+}
 
-		return tag, candidates, nil
+// gets a tag
+func (s *githubSource) ReleaseByTag(u *url.URL, tag string) (*Release, error) {
+	json, err := s.apiRequest(fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", s.PackageName(u), url.PathEscape(tag)))
+	if err != nil {
+		return nil, err
 	}
 
-	return "", nil, fmt.Errorf("no valid release found")
+	rel := githubParseRelease(gjson.Parse(json))
+	return &rel, nil
 }
 
-// use githubGetReleases to get the json
-func githubGetCandidatesFromRelease(json string, assetsPath string, assetCount int64) []string {
-	var candidates []string
+// sets the github token on a request, if there is one
+func (s *githubSource) Authorize(req *http.Request) {
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+}
 
-	for i := range assetCount {
-		assetPath := fmt.Sprintf("%s.%d", assetsPath, i)
-		candidates = append(candidates, gjson.Get(json, assetPath+".browser_download_url").String())
+// sends a request to the github api
+func (s *githubSource) apiRequest(link string) (string, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return "", err
 	}
 
-	return candidates
-}
+	// set headers
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	s.Authorize(req)
 
-func githubFormatCandidates(json string, assetsPath string) ([]string, error) {
-	assetCount := gjson.Get(json, fmt.Sprintf("%s.#", assetsPath)).Int()
+	body, err := apiRequest(req)
 
-	if assetCount == 0 {
-		return nil, fmt.Errorf("no assets available")
+	var rle *rateLimitError
+	if errors.As(err, &rle) && s.token == "" {
+		return "", fmt.Errorf("%w (anonymous requests have a low limit, consider setting a token)", err)
 	}
 
-	// get and filter candidates (release files)
-	candidates := githubGetCandidatesFromRelease(json, assetsPath, assetCount)
-	candidates, err := filterCandidates(candidates)
+	return body, err
+}
 
-	if err != nil {
-		return candidates, err
+// turns a github-style release object into a Release. gitea uses the same shape.
+func githubParseRelease(r gjson.Result) Release {
+	rel := Release{
+		Tag:        r.Get("tag_name").String(),
+		Prerelease: r.Get("prerelease").Bool(),
+	}
+
+	for _, a := range r.Get("assets").Array() {
+		rel.Assets = append(rel.Assets, Asset{
+			Name: a.Get("name").String(),
+			Url:  a.Get("browser_download_url").String(),
+			Size: a.Get("size").Int(),
+		})
 	}
 
-	return candidates, nil
+	return rel
 }
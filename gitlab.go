@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/ini.v1"
+)
+
+// gitlab.com and self-hosted gitlab releases
+type gitlabSource struct {
+	tokens map[string]string // by host. hosts without one are anonymous.
+}
+
+func init() {
+	registerSource(&gitlabSource{}, "gitlab.com")
+}
+
+// reads extra gitlab hosts and their tokens from config.ini, $GITLAB_TOKEN or the token files
+func (s *gitlabSource) Configure(cfg *ini.File) error {
+	var hosts []string
+	for _, h := range cfg.Section("gitlab").Key("Hosts").Strings(",") {
+		if h != "" {
+			addSourceKeys(s, h)
+			hosts = append(hosts, h)
+		}
+	}
+
+	tokens, err := readHostTokens(cfg, "gitlab", "GITLAB_TOKEN", "gitlab.com", hosts)
+	if err != nil {
+		return fmt.Errorf("gitlab token: %s", err)
+	}
+
+	s.tokens = tokens
+	return nil
+}
+
+// host/group/sub/project, without any web ui suffix
+func (s *gitlabSource) Normalize(u *url.URL) (*url.URL, error) {
+	p := strings.Trim(u.Path, "/")
+
+	// links copied from the web ui, like group/project/-/releases
+	p, _, _ = strings.Cut(p, "/-/")
+	p = strings.TrimSuffix(p, ".git")
+
+	if !strings.Contains(p, "/") {
+		return nil, fmt.Errorf("gitlab links should look like %s/group/project", u.Host)
+	}
+
+	return &url.URL{Scheme: "https", Host: u.Host, Path: "/" + p}, nil
+}
+
+// group/sub/project
+func (s *gitlabSource) PackageName(u *url.URL) string {
+	return strings.Trim(u.Path, "/")
+}
+
+// uses gitlab api to get a project's releases
+func (s *gitlabSource) Releases(u *url.URL, depth int) ([]Release, error) {
+	// gitlab caps per_page at 100
+	json, err := s.apiRequest(u.Host, fmt.Sprintf("projects/%s/releases?per_page=%d", url.PathEscape(s.PackageName(u)), min(depth, 100)))
+	if err != nil {
+		return nil, err
+	}
+
+	if !gjson.Parse(json).IsArray() {
+		return nil, fmt.Errorf("unexpected response from %s", u.Host)
+	}
+
+	var releases []Release
+	for _, r := range gjson.Parse(json).Array() {
+		releases = append(releases, gitlabParseRelease(r))
+	}
+
+	return releases, nil
+}
+
+// gets a release by tag
+func (s *gitlabSource) ReleaseByTag(u *url.URL, tag string) (*Release, error) {
+	json, err := s.apiRequest(u.Host, fmt.Sprintf("projects/%s/releases/%s", url.PathEscape(s.PackageName(u)), url.PathEscape(tag)))
+	if err != nil {
+		return nil, err
+	}
+
+	rel := gitlabParseRelease(gjson.Parse(json))
+	return &rel, nil
+}
+
+// sets the token of the request's host, if it has one. as a bearer token, because go drops Authorization on redirects
+// to other hosts (where asset links often point), but would pass PRIVATE-TOKEN along.
+func (s *gitlabSource) Authorize(req *http.Request) {
+	if t := s.tokens[req.URL.Host]; t != "" {
+		req.Header.Set("Authorization", "Bearer "+t)
+	}
+}
+
+// sends a request to a gitlab instance's api
+func (s *gitlabSource) apiRequest(host, path string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/api/v4/%s", host, path), nil)
+	if err != nil {
+		return "", err
+	}
+
+	s.Authorize(req)
+
+	return apiRequest(req)
+}
+
+// turns a gitlab release object into a Release
+func gitlabParseRelease(r gjson.Result) Release {
+	rel := Release{
+		Tag: r.Get("tag_name").String(),
+
+		// gitlab has no prereleases, but upcoming releases are close enough
+		Prerelease: r.Get("upcoming_release").Bool(),
+	}
+
+	for _, link := range r.Get("assets.links").Array() {
+		// direct_asset_url is the stable permalink, url is wherever the link actually points
+		href := link.Get("direct_asset_url").String()
+		if href == "" || !strings.HasSuffix(href, ".deb") {
+			href = link.Get("url").String()
+		}
+
+		name := href
+		if lu, err := url.Parse(href); err == nil {
+			name = path.Base(lu.Path)
+		}
+
+		rel.Assets = append(rel.Assets, Asset{Name: name, Url: href})
+	}
+
+	return rel
+}
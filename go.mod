@@ -3,12 +3,18 @@ module yadeb
 go 1.24.4
 
 require (
+	github.com/ProtonMail/go-crypto v1.5.2
+	github.com/klauspost/compress v1.18.0
 	github.com/tidwall/gjson v1.18.0
+	github.com/ulikunitz/xz v0.5.15
 	gopkg.in/ini.v1 v1.67.0
 )
 
 require (
+	github.com/cloudflare/circl v1.6.3 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 )
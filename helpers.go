@@ -1,26 +1,54 @@
 package main
 
 import (
+	"cmp"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
-// return to caveman
-func containsAny(s string, needles []string) bool {
-	for _, n := range needles {
-		if strings.Contains(s, n) {
+// does a file name mention an architecture alias as a whole word? x86 doesn't match x86_64, x64 doesn't match
+// linux64x64bit, but foo_1.0_x64.deb does.
+func nameHasArch(name, alias string) bool {
+	name = strings.ToLower(name)
+	isWordChar := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+	}
+
+	for i := 0; ; {
+		j := strings.Index(name[i:], alias)
+		if j < 0 {
+			return false
+		}
+
+		start, end := i+j, i+j+len(alias)
+		before := start == 0 || !isWordChar(name[start-1])
+		after := end == len(name) || !isWordChar(name[end]) && !strings.HasPrefix(name[end+1:], "64")
+		if before && after {
 			return true
 		}
+
+		i = start + 1
 	}
-	return false
+}
+
+// does a file name mention any of an architecture's aliases?
+func nameHasAnyArch(name string, aliases []string) bool {
+	return slices.ContainsFunc(aliases, func(alias string) bool {
+		return nameHasArch(name, alias)
+	})
 }
 
 // \033[91mError:\033[0m {s}
@@ -33,42 +61,270 @@ func lnAnsiError(s ...string) {
 	fmt.Println("\n\033[91mError\033[0m:", strings.Join(s, " "))
 }
 
-// downloads file
-func downloadFile(href, path string) error {
-	// check if file already exists
-	if _, err := os.Stat(path); err == nil {
-		return fmt.Errorf("%s already exists", path)
-	} else if !os.IsNotExist(err) {
-		return err // ????
+// the first of ss that isn't blank, or ""
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if strings.TrimSpace(s) != "" {
+			return s
+		}
+	}
+
+	return ""
+}
+
+// compares version strings like 1.10.2 and v1.9, numbers by value. like dpkg's ~, a -, ~ or + suffix
+// (1.0-rc1) is a pre-release that sorts below the bare version. returns -1, 0 or 1 like strings.Compare.
+func compareVersions(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+
+	for a != "" && b != "" {
+		var pa, pb string
+		pa, a = versionChunk(a)
+		pb, b = versionChunk(b)
+
+		na, errA := strconv.ParseUint(pa, 10, 64)
+		nb, errB := strconv.ParseUint(pb, 10, 64)
+
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				return cmp.Compare(na, nb)
+			}
+		case pa != pb:
+			// 1.0-rc1 is older than 1.0.1
+			if isPrereleaseSuffix(pa) != isPrereleaseSuffix(pb) {
+				return prereleaseOrder(pa)
+			}
+
+			return strings.Compare(pa, pb)
+		}
+	}
+
+	// one ran out. whatever's left of the other is newer, unless it's a pre-release.
+	switch {
+	case a != "":
+		return prereleaseOrder(a)
+	case b != "":
+		return -prereleaseOrder(b)
+	}
+
+	return 0
+}
+
+// -1 if a version goes on with a pre-release suffix (older), 1 if it goes on with anything else (newer)
+func prereleaseOrder(rest string) int {
+	if isPrereleaseSuffix(rest) {
+		return -1
+	}
+
+	return 1
+}
+
+// does what's left of a version start a pre-release, like the -rc1 of 1.0-rc1?
+func isPrereleaseSuffix(s string) bool {
+	return s != "" && strings.ContainsRune("-~+", rune(s[0]))
+}
+
+// splits off the leading run of digits or non-digits
+func versionChunk(s string) (string, string) {
+	digit := s[0] >= '0' && s[0] <= '9'
+
+	i := 1
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == digit {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+// debian's name for the architecture yadeb runs on, as dpkg reports it
+func debianArch() string {
+	if hostArchitecture == "" {
+		hostArchitecture = goDebianArch()
+		if out, err := exec.Command("dpkg", "--print-architecture").Output(); err == nil {
+			hostArchitecture = strings.TrimSpace(string(out))
+		}
+	}
+
+	return hostArchitecture
+}
+
+// guess of the debian architecture from what we were built for, if there's no dpkg to ask
+func goDebianArch() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	case "ppc64le":
+		return "ppc64el"
+	case "mips64le":
+		return "mips64el"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// architectures dpkg was told to accept besides the native one (dpkg --add-architecture)
+func foreignArchs() []string {
+	if foreignArchitectures == nil {
+		foreignArchitectures = []string{}
+		if out, err := exec.Command("dpkg", "--print-foreign-architectures").Output(); err == nil {
+			foreignArchitectures = strings.Fields(string(out))
+		}
+	}
+
+	return foreignArchitectures
+}
+
+// errors if packages for arch can't be installed here
+func checkArch(arch string) error {
+	if arch == debianArch() || slices.Contains(foreignArchs(), arch) {
+		return nil
+	}
+
+	if _, ok := architectureAliases[arch]; !ok {
+		return fmt.Errorf("unknown architecture: %s", arch)
+	}
+
+	return fmt.Errorf("%s isn't enabled, add it with: dpkg --add-architecture %s && apt update", arch, arch)
+}
+
+// errors if a file holding secrets can be read by anyone
+func checkSecretFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Perm()&0004 != 0 {
+		return fmt.Errorf("%s is world-readable (chmod 600 it)", path)
+	}
+
+	return nil
+}
+
+// reads a token from a root-only file. a missing file means no token.
+func readTokenFile(path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	if err := checkSecretFile(path); err != nil {
+		return "", fmt.Errorf("refusing to read token: %s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// does path exist?
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// replaces a file without ever leaving it half-written: write a temp file next to it, fsync, then rename over it
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
 	}
 
-	// get the page
-	resp, err := http.Get(href)
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// make the rename itself durable
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer d.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	return d.Sync()
+}
+
+// copies a file, refusing to overwrite
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
 	}
+	defer in.Close()
 
-	// create file
-	out, err := os.Create(path)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	// download
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
+	if _, err = io.Copy(out, in); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// moves a file, copying it when it's on another filesystem
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// hashes a file, hex-encoded
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // generates random b64 str
 func randomBase64(length int) (string, error) {
 	numBytes := (length * 3) / 4
@@ -80,9 +336,11 @@ func randomBase64(length int) (string, error) {
 	return base64.RawURLEncoding.EncodeToString(randomBytes)[:length], nil
 }
 
-// runs apt with args, fully passing stdin, stdout, and stderr
-func runApt(args ...string) error {
+// runs apt with args and extra environment variables, fully passing stdin, stdout, and stderr
+func runApt(env []string, args ...string) error {
 	cmd := exec.Command("/usr/bin/apt", args...)
+	cmd.Env = append(os.Environ(), env...)
+
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -146,20 +404,68 @@ func createTempDir() (string, error) {
 	return tempDir, nil
 }
 
-// creates a "unix-style" numbered menu.
-// returns: valid, selected index
-func numberedMenu(values []string) (bool, int) {
-	for i, v := range values {
-		fmt.Printf("[%d] %s\n", i+1, v)
-	}
+// is stdin something a person can type into?
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// is stdout a terminal, where output can be redrawn?
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// creates a "unix-style" numbered menu, asking until the answer is valid or input runs out.
+// multi allows several comma or space separated choices.
+// returns: selected indexes (without duplicates)
+func numberedMenu(values []string, multi bool) ([]int, error) {
+	for {
+		for i, v := range values {
+			fmt.Printf("[%d] %s\n", i+1, v)
+		}
+
+		if multi {
+			fmt.Print("Enter your option(s): ")
+		} else {
+			fmt.Print("Enter your option: ")
+		}
+
+		line, err := menuInput.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println()
+			return nil, fmt.Errorf("no choice made: %s", err)
+		}
+
+		if choices, ok := parseMenuChoices(line, len(values), multi); ok {
+			return choices, nil
+		}
 
-	fmt.Print("Enter your option: ")
-	var choice int
-	_, err := fmt.Scan(&choice)
-	if err != nil || choice < 1 || choice > len(values) {
 		fmt.Println("Invalid choice")
-		return false, 0
+	}
+}
+
+// reads menu choices (1-based) from a line of input
+func parseMenuChoices(line string, n int, multi bool) ([]int, bool) {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	if len(fields) == 0 || !multi && len(fields) != 1 {
+		return nil, false
+	}
+
+	var choices []int
+	for _, field := range fields {
+		choice, err := strconv.Atoi(field)
+		if err != nil || choice < 1 || choice > n {
+			return nil, false
+		}
+
+		if !slices.Contains(choices, choice-1) {
+			choices = append(choices, choice-1)
+		}
 	}
 
-	return true, choice - 1
+	return choices, true
 }
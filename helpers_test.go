@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// answers menus with input, as if it was typed
+func fakeMenuInput(t *testing.T, input string) {
+	t.Helper()
+
+	oldInput, oldNonInteractive := menuInput, nonInteractive
+	menuInput = bufio.NewReader(strings.NewReader(input))
+	nonInteractive = false
+
+	t.Cleanup(func() {
+		menuInput, nonInteractive = oldInput, oldNonInteractive
+	})
+}
+
+func TestParseMenuChoices(t *testing.T) {
+	tests := []struct {
+		line  string
+		n     int
+		multi bool
+		want  []int
+		ok    bool
+	}{
+		{"2\n", 3, false, []int{1}, true},
+		{" 3 ", 3, false, []int{2}, true},
+		{"0", 3, false, nil, false},
+		{"4", 3, false, nil, false},
+		{"x", 3, false, nil, false},
+		{"", 3, false, nil, false},
+		{"1,2", 3, false, nil, false},
+		{"1,3", 3, true, []int{0, 2}, true},
+		{"1 3", 3, true, []int{0, 2}, true},
+		{"3, 1,3 1", 3, true, []int{2, 0}, true},
+		{"1,4", 3, true, nil, false},
+		{",", 3, true, nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseMenuChoices(tt.line, tt.n, tt.multi)
+		if ok != tt.ok || !slices.Equal(got, tt.want) {
+			t.Errorf("parseMenuChoices(%q, %d, %v) = %v, %v; want %v, %v", tt.line, tt.n, tt.multi, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNumberedMenuRetriesInvalidInput(t *testing.T) {
+	fakeMenuInput(t, "nope\n7\n2\n")
+
+	got, err := numberedMenu([]string{"a", "b", "c"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(got, []int{1}) {
+		t.Errorf("got %v, want [1]", got)
+	}
+}
+
+func TestNumberedMenuEOF(t *testing.T) {
+	for _, input := range []string{"", "nope\n"} {
+		fakeMenuInput(t, input)
+
+		if got, err := numberedMenu([]string{"a", "b"}, false); err == nil {
+			t.Errorf("input %q: got %v, want an error", input, got)
+		}
+	}
+}
+
+func TestNumberedMenuLastLineWithoutNewline(t *testing.T) {
+	fakeMenuInput(t, "2")
+
+	got, err := numberedMenu([]string{"a", "b"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(got, []int{1}) {
+		t.Errorf("got %v, want [1]", got)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"v1.0", "1.0", 0},
+		{"1.10", "1.9", 1},
+		{"1.9", "1.10", -1},
+		{"1.0.1", "1.0", 1},
+		{"2.0", "10.0", -1},
+		{"1.0", "1.0-rc1", 1},
+		{"1.0-rc1", "1.0", -1},
+		{"1.0~beta", "1.0", -1},
+		{"1.0+git1", "1.0", -1},
+		{"1.0-rc1", "1.0.1", -1},
+		{"1.0-rc2", "1.0-rc10", -1},
+		{"1.0-beta", "1.0-rc1", -1},
+		{"1.0-rc1", "0.9", 1},
+		{"1.0a", "1.0", 1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d; want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"net/url"
 	"os"
 	"path/filepath"
-	"runtime"
+	"regexp"
 	"slices"
 	"strings"
 	"syscall"
@@ -14,7 +14,7 @@ import (
 )
 
 // the install command
-func cmdInstall(links []string, tagFlag string) int {
+func cmdInstall(links []string, opts InstallOptions) int {
 	if len(links) == 0 {
 		ansiError("Nothing to install")
 		return 2
@@ -25,90 +25,258 @@ func cmdInstall(links []string, tagFlag string) int {
 		return 2
 	}
 
-	if err := createConfigDir(); err != nil {
-		ansiError("Couldn't create (or check existence of) /etc/yadeb")
+	// local files skip the source entirely, but we still need to know what they are
+	if opts.File != "" && opts.Tag == "latest" {
+		ansiError("Installing from a file requires --tag")
+		return 2
+	}
+
+	if opts.File != "" && len(links) != 1 {
+		ansiError("Installing from a file only works with one link")
+		return 2
+	}
+
+	if opts.File == "" && (opts.Signature != "" || opts.Sha256 != "") {
+		ansiError("--signature and --sha256 only work with --file")
+		return 2
+	}
+
+	if opts.File != "" && (opts.Sigstore.Issuer != "" || opts.Sigstore.Subject != "") {
+		ansiError("Local files have no sigstore bundles to check")
+		return 2
+	}
+
+	// an issuer alone would let anyone it ever issued a certificate to sign the package
+	if (opts.Sigstore.Issuer == "") != (opts.Sigstore.Subject == "") {
+		ansiError("--sigstore-issuer and --sigstore-subject only work together")
+		return 2
+	}
+
+	if opts.Sigstore.Subject != "" {
+		if _, err := regexp.Compile(opts.Sigstore.Subject); err != nil {
+			ansiError("Invalid sigstore subject:", err.Error())
+			return 2
+		}
+	}
+
+	if opts.Sha256 != "" && !isSha256(opts.Sha256) {
+		ansiError("Invalid SHA-256:", opts.Sha256)
+		return 2
+	}
+
+	if opts.AssetPattern != "" {
+		if _, err := regexp.Compile(opts.AssetPattern); err != nil {
+			ansiError("Invalid asset pattern:", err.Error())
+			return 2
+		}
+	}
+
+	arch := debianArch()
+	if opts.Arch != "" {
+		if err := checkArch(opts.Arch); err != nil {
+			ansiError(err.Error())
+			return 2
+		}
+
+		arch = opts.Arch
+	}
+
+	lock, err := acquireLock()
+	if err != nil {
+		ansiError(err.Error())
 		return 1
 	}
+	defer releaseLock(lock)
 
-	cfg, err := ini.Load("/etc/yadeb/config.ini")
+	cfg, err := loadConfig()
 	if err != nil {
-		ansiError("Couldn't read /etc/yadeb/config.ini")
+		ansiError(err.Error())
 		return 1
 	}
 
-	// "common hack"
-	raw := links[0]
-	if !strings.Contains(raw, "://") {
-		raw = "https://" + raw
+	var (
+		summary runSummary
+		pkgs    []PackageToInstall
+		indexes []int // pkgs' places in summary
+	)
+
+	// resolve everything first, so choices are made before anything is downloaded
+	for _, link := range links {
+		pii, err := resolveInstall(link, opts, arch, cfg)
+		if err != nil {
+			ansiError(err.Error())
+			summary.add(displayLink(link), err, "")
+			continue
+		}
+
+		if pii == nil {
+			summary.add(displayLink(link), nil, "already installed")
+			continue
+		}
+
+		indexes = append(indexes, summary.add(displayLink(pii.Url.String()), nil, ""))
+		pkgs = append(pkgs, *pii)
 	}
 
-	// parse link
-	u, err := url.Parse(raw)
-	if err != nil {
-		ansiError("Couldn't parse link:", err.Error())
-		return 1
+	if opts.DryRun || opts.Simulate {
+		return showPlan(cfg, opts.RunOptions, &summary, pkgs, indexes)
 	}
 
-	// error out if unknown scheme
-	if !slices.Contains([]string{"https", ""}, u.Scheme) {
-		ansiError("Unknown source scheme:", u.Scheme)
-		return 2
+	if len(pkgs) != 0 {
+		for i, err := range installCandidates(cfg, pkgs...) {
+			summary.set(indexes[i], err, "installed "+pkgs[i].Tag)
+		}
+	}
+
+	return summary.print()
+}
+
+// finds what to install for a link. nil, nil if it's already installed.
+func resolveInstall(link string, opts InstallOptions, arch string, cfg *ini.File) (*PackageToInstall, error) {
+	u, src, err := parseLink(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link: %s", err)
 	}
 
-	// error if already installed
+	// skip if already installed
 	p, err := getPackage(u.String())
 	if err != nil {
-		ansiError("Couldn't read installed package database:", err.Error())
-		return 1
+		return nil, fmt.Errorf("couldn't read installed package database: %s", err)
 	}
 	if p != nil {
 		fmt.Fprintln(os.Stderr, u.String(), "is already installed")
-		return 0
+		return nil, nil
 	}
 
-	// init architecture slice
-	for _, v := range architectureAliases {
-		allArchitectures = append(allArchitectures, v...)
+	if opts.Version.Url != "" {
+		setVersionRule(u.String(), opts.Version)
 	}
 
-	var (
-		candidates []string
-		pkgName    string
-		tag        string
-	)
+	pkgName := src.PackageName(u)
 
-	// decide what to do based on domain
-	switch u.Host {
-	case "github.com":
-		candidates, pkgName, tag, err = githubGetCandidates(u, tagFlag, cfg)
-	default:
-		ansiError("Unknown source domain:", u.Host)
-		return 2
+	if opts.File != "" {
+		a, err := localAsset(opts, u.String(), cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PackageToInstall{
+			Name:   pkgName,
+			Tag:    opts.Tag,
+			Assets: []Asset{a},
+			Url:    u,
+			Local:  true,
+			Mark: &Package{
+				Link:         u.String(),
+				InstalledTag: opts.Tag,
+				LocalFile:    true,
+			},
+		}, nil
 	}
 
+	rel, candidates, err := resolveRelease(src, u, opts.Tag, arch, cfg)
 	if err != nil {
-		ansiError("Failed to get candidates:", err.Error())
-		return 1
+		return nil, fmt.Errorf("failed to get candidates: %s", err)
 	}
 
-	if len(candidates) != 1 {
-		installUserChoice(candidates)
+	// some releases ship several packages (foo, foo-dbgsym...) and more than one can be wanted
+	if opts.AssetPattern != "" {
+		a, err := matchAssetPattern(rel, opts.AssetPattern)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = []Asset{a}
+	} else if candidates = filterDistro(candidates, ""); len(candidates) != 1 {
+		if candidates, err = chooseAssets(candidates, cfg); err != nil {
+			return nil, err
+		}
 	}
 
-	// downlad the remaining candidate
-	if err := candidateInstall(pkgName, tag, candidates[0], u); err != nil {
-		ansiError(fmt.Sprintf("Couldn't install %s: %s", pkgName, err.Error()))
-		return 1
+	if err := prepareAssets(rel, candidates, u.String(), opts.Sigstore, cfg); err != nil {
+		return nil, err
 	}
 
-	return 0
+	return &PackageToInstall{
+		Name:   pkgName,
+		Tag:    rel.Tag,
+		Assets: candidates,
+		Distro: assetsDistro(candidates),
+		Url:    u,
+		Mark: &Package{
+			Link:         u.String(),
+			InstalledTag: rel.Tag,
+			VersionUrl:   opts.Version.Url,
+			VersionPath:  opts.Version.Path,
+			VersionRegex: opts.Version.Regex,
+			AssetPattern: opts.AssetPattern,
+			Architecture: opts.Arch,
+			Distro:       assetsDistro(candidates),
+
+			SigstoreIssuer:  opts.Sigstore.Issuer,
+			SigstoreSubject: opts.Sigstore.Subject,
+		},
+	}, nil
 }
 
-// filters candidates from name
-func filterCandidates(candidates []string) ([]string, error) {
+// the asset of a --file install. the link's trusted keys and RequireChecksum apply to it like they do to downloads,
+// with the signature and checksum given or found next to the file.
+func localAsset(opts InstallOptions, link string, cfg *ini.File) (Asset, error) {
+	a := Asset{Name: filepath.Base(opts.File), Url: opts.File, Checksum: strings.ToLower(opts.Sha256)}
+
+	if a.Checksum == "" {
+		for _, name := range []string{opts.File + ".sha256", opts.File + ".sha256sum"} {
+			f, err := os.Open(name)
+			if err != nil {
+				continue
+			}
+
+			a.Checksum, err = parseChecksums(f, a.Name, true)
+			f.Close()
+			if err != nil {
+				return a, fmt.Errorf("couldn't read %s: %s", name, err)
+			}
+
+			break
+		}
+	}
+
+	policy := cfg.Section("yadeb").Key("RequireChecksum").In("if-available", []string{"never", "if-available", "always"})
+	if a.Checksum == "" && policy == "always" {
+		return a, fmt.Errorf("%s has no checksum, and RequireChecksum is always (use --sha256 or put %s.sha256 next to it)", opts.File, a.Name)
+	}
+
+	keyring, err := loadKeyring(link)
+	if err != nil {
+		return a, fmt.Errorf("couldn't read trusted keys: %s", err)
+	}
+
+	if keyring == nil {
+		return a, nil
+	}
+
+	a.Signature = opts.Signature
+	if a.Signature == "" {
+		for _, name := range signatureNames(opts.File) {
+			if fileExists(name) {
+				a.Signature = name
+				break
+			}
+		}
+	}
+
+	if a.Signature == "" {
+		return a, fmt.Errorf("%s is pinned to trusted keys, but %s has no signature (use --signature or put %s.asc next to it)", link, opts.File, a.Name)
+	}
+
+	return a, nil
+}
+
+// filters candidates down to .debs for arch. ambiguous ones are told apart by their control files.
+func filterCandidates(candidates []Asset, arch string) ([]Asset, error) {
 	// .deb filtering
-	candidates = slices.DeleteFunc(candidates, func(v string) bool {
-		return !strings.HasSuffix(v, ".deb")
+	candidates = slices.DeleteFunc(candidates, func(a Asset) bool {
+		return !strings.HasSuffix(a.Name, ".deb")
 	})
 
 	if len(candidates) == 1 {
@@ -117,98 +285,205 @@ func filterCandidates(candidates []string) ([]string, error) {
 		return candidates, fmt.Errorf("no package files found")
 	}
 
-	// match any arch to see if they exist
-	archSpecific := false
-	for _, v := range candidates {
-		if containsAny(v, allArchitectures) {
-			archSpecific = true
-			break
+	fmt.Printf("Reading metadata of %d package files...", len(candidates))
+	filtered, err := filterByControl(candidates, arch)
+	if err != nil {
+		// names are the next best thing
+		fmt.Printf(" \033[93mFailed (%s), guessing from file names\033[0m\n", err)
+		return filterByName(candidates, arch)
+	}
+	fmt.Println(doneMsg)
+
+	if len(filtered) == 0 {
+		return filtered, fmt.Errorf("no package files for %s", arch)
+	}
+
+	return filtered, nil
+}
+
+// keeps the candidates whose control file says they're for arch (or any architecture).
+// errors only if a control file couldn't be read.
+func filterByControl(candidates []Asset, arch string) ([]Asset, error) {
+	var filtered []Asset
+	for _, a := range candidates {
+		ctl, err := fetchDebControl(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", a.Name, err)
+		}
+
+		if ctl.Architecture == arch || ctl.Architecture == "all" {
+			a.Control = ctl
+			filtered = append(filtered, a)
 		}
 	}
 
+	return filtered, nil
+}
+
+// filters candidates by architecture names in their file names
+func filterByName(candidates []Asset, arch string) ([]Asset, error) {
+	// match any arch to see if they exist
+	archSpecific := slices.ContainsFunc(candidates, func(a Asset) bool {
+		for _, aliases := range architectureAliases {
+			if nameHasAnyArch(a.Name, aliases) {
+				return true
+			}
+		}
+
+		return false
+	})
+
 	if !archSpecific {
 		return candidates, nil
 	}
 
-	// look for current architecture
-	candidates = slices.DeleteFunc(candidates, func(v string) bool {
-		return !containsAny(v, architectureAliases[runtime.GOARCH])
+	// look for the wanted architecture
+	candidates = slices.DeleteFunc(candidates, func(a Asset) bool {
+		return !nameHasAnyArch(a.Name, architectureAliases[arch])
 	})
 
 	if len(candidates) == 0 {
-		return candidates, fmt.Errorf("no package files for %s", runtime.GOARCH)
+		return candidates, fmt.Errorf("no package files for %s", arch)
 	}
 
 	return candidates, nil
 }
 
-// installs a candidate
-func candidateInstall(pkgName, tag, downloadLink string, u *url.URL) error {
-	// create
-	tempDir, err := createTempDir()
+// finds the one asset of rel whose whole name matches pattern. architecture filtering doesn't apply, the pattern decides.
+func matchAssetPattern(rel *Release, pattern string) (Asset, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
 	if err != nil {
-		return fmt.Errorf("couldn't create temp directory: %s", err)
+		return Asset{}, fmt.Errorf("invalid asset pattern: %s", err)
 	}
 
-	path := fmt.Sprintf("%s/%s", tempDir, filepath.Base(downloadLink))
+	var matches []Asset
+	for _, a := range rel.Assets {
+		if re.MatchString(a.Name) {
+			matches = append(matches, a)
+		}
+	}
 
-	// download
-	fmt.Printf("Downloading %s from release %s...", filepath.Base(downloadLink), tag)
-	if err := downloadFile(downloadLink, path); err != nil {
-		fmt.Println()
-		cleanupDir(tempDir) // Yes, I want to use a defer, but I need to return the value at the end so I can't.
-		return fmt.Errorf("couldn't download selected candidate: %s", err)
+	switch len(matches) {
+	case 0:
+		return Asset{}, fmt.Errorf("release %s has no asset matching %s", rel.Tag, pattern)
+	case 1:
+		return matches[0], nil
 	}
-	fmt.Println(doneMsg)
 
-	// mark
-	fmt.Printf("Marking %s as installed...", pkgName)
-	if err := markAsInstalled(path, u.String(), tag); err != nil {
-		fmt.Println()
-		cleanupDir(tempDir)
-		return fmt.Errorf("couldn't mark %s as installed: %s", pkgName, err)
+	names := make([]string, len(matches))
+	for i, a := range matches {
+		names[i] = a.Name
 	}
-	fmt.Println(doneMsg)
 
-	// apt
-	fmt.Printf("Starting APT (%s)...\n\n", os.Args[1])
-	if err := runApt(os.Args[1], path); err != nil {
-		// if apt fails then unmark the package
-		fmt.Printf("Removing installation mark for %s...", pkgName)
-		if err := unmarkAsInstalled(u.String()); err != nil {
-			fmt.Println()
-			cleanupDir(tempDir)
-			ansiError("couldn't run apt:", err.Error())
-			return fmt.Errorf("couldn't remove installation mark for %s: %s", pkgName, err)
-		}
-		fmt.Println(doneMsg)
+	return Asset{}, fmt.Errorf("release %s has %d assets matching %s (%s), make the pattern stricter", rel.Tag, len(matches), pattern, strings.Join(names, ", "))
+}
+
+// a choice that has to be made by someone, but nobody's there
+type ambiguousError struct {
+	Choices []string
+}
+
+func (e *ambiguousError) Error() string {
+	return fmt.Sprintf("several package files fit and yadeb isn't interactive, narrow them down with --asset-pattern or [yadeb] AssetPreference: %s", strings.Join(e.Choices, ", "))
+}
 
-		return fmt.Errorf("couldn't run apt: %s", err)
+// the exit code an error should end yadeb with
+func exitCode(err error) int {
+	var ae *ambiguousError
+	if errors.As(err, &ae) {
+		return exitAmbiguous
 	}
 
-	return cleanupDir(tempDir)
+	return 1
 }
 
-// asks user which remaining candidate to install
-func installUserChoice(candidates []string) []string {
-	fmt.Println("There are multiple package files that can be installed. Choose which one to install:")
-	valid := false
-	index := 0
+// narrows candidates down with [yadeb] AssetPreference, a comma separated list of regexes tried in order.
+// each one that matches anything keeps only what it matches.
+func preferredAssets(candidates []Asset, cfg *ini.File) ([]Asset, error) {
+	for _, pattern := range cfg.Section("yadeb").Key("AssetPreference").Strings(",") {
+		if len(candidates) == 1 {
+			break
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AssetPreference %s: %s", pattern, err)
+		}
+
+		matched := slices.DeleteFunc(slices.Clone(candidates), func(a Asset) bool {
+			return !re.MatchString(a.Name)
+		})
+
+		if len(matched) != 0 {
+			candidates = matched
+		}
+	}
 
-	slices.Sort(candidates)
-	displayCandidates := append([]string(nil), candidates...)
+	if len(candidates) != 1 {
+		names := make([]string, len(candidates))
+		for i, a := range candidates {
+			names[i] = a.Name
+		}
 
-	for i, v := range displayCandidates {
-		displayCandidates[i] = filepath.Base(v)
+		return nil, &ambiguousError{names}
 	}
 
-	for !valid {
-		valid, index = numberedMenu(displayCandidates)
+	return candidates, nil
+}
+
+// asks user which one of candidates to install. candidates itself is left alone.
+func chooseAsset(candidates []Asset, cfg *ini.File) (Asset, error) {
+	chosen, err := pickAssets(candidates, false, cfg)
+	if err != nil {
+		return Asset{}, err
 	}
 
-	wantedVal := candidates[index]
+	return chosen[0], nil
+}
+
+// asks user which of candidates to install. more than one can be picked.
+func chooseAssets(candidates []Asset, cfg *ini.File) ([]Asset, error) {
+	return pickAssets(candidates, true, cfg)
+}
 
-	return slices.DeleteFunc(candidates, func(v string) bool {
-		return v != wantedVal
+// shows candidates, sorted by name, in a menu and returns the picked ones.
+// without anyone to ask, [yadeb] AssetPreference picks.
+func pickAssets(candidates []Asset, multi bool, cfg *ini.File) ([]Asset, error) {
+	if len(candidates) == 1 {
+		return candidates, nil
+	}
+
+	if nonInteractive {
+		return preferredAssets(candidates, cfg)
+	}
+
+	sorted := slices.SortedFunc(slices.Values(candidates), func(a, b Asset) int {
+		return strings.Compare(a.Name, b.Name)
 	})
+
+	display := make([]string, len(sorted))
+	for i, a := range sorted {
+		display[i] = a.Name
+		if a.Control != nil {
+			display[i] += fmt.Sprintf(" (%s %s, %s)", a.Control.Package, a.Control.Version, a.Control.Architecture)
+		}
+	}
+
+	if multi {
+		fmt.Println("There are multiple package files that can be installed. Choose which ones to install (like 1 or 1,3):")
+	} else {
+		fmt.Println("There are multiple package files that can be installed. Choose which one to install:")
+	}
+
+	indexes, err := numberedMenu(display, multi)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := make([]Asset, len(indexes))
+	for i, index := range indexes {
+		chosen[i] = sorted[index]
+	}
+
+	return chosen, nil
 }
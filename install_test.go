@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+// in the order a release might list them, which isn't the menu's
+var menuAssets = []Asset{
+	{Name: "tool_1.0_amd64.deb"},
+	{Name: "tool-dbgsym_1.0_amd64.deb"},
+	{Name: "libtool_1.0_amd64.deb"},
+}
+
+func assetNames(assets []Asset) []string {
+	var names []string
+	for _, a := range assets {
+		names = append(names, a.Name)
+	}
+
+	return names
+}
+
+func TestPickAssetsReturnsSortedChoice(t *testing.T) {
+	// sorted: [1] libtool, [2] tool-dbgsym, [3] tool
+	fakeMenuInput(t, "3\n")
+
+	a, err := chooseAsset(menuAssets, ini.Empty())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Name != "tool_1.0_amd64.deb" {
+		t.Errorf("got %s, want tool_1.0_amd64.deb", a.Name)
+	}
+}
+
+func TestPickAssetsMulti(t *testing.T) {
+	fakeMenuInput(t, "3,1,3\n")
+
+	got, err := chooseAssets(menuAssets, ini.Empty())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"tool_1.0_amd64.deb", "libtool_1.0_amd64.deb"}
+	if !slices.Equal(assetNames(got), want) {
+		t.Errorf("got %v, want %v", assetNames(got), want)
+	}
+}
+
+func TestPickAssetsKeepsCallerOrder(t *testing.T) {
+	fakeMenuInput(t, "1\n")
+
+	candidates := slices.Clone(menuAssets)
+	if _, err := chooseAsset(candidates, ini.Empty()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(assetNames(candidates), assetNames(menuAssets)) {
+		t.Errorf("candidates were reordered to %v", assetNames(candidates))
+	}
+}
+
+func TestPickAssetsInvalidThenValid(t *testing.T) {
+	fakeMenuInput(t, "0\nlibtool\n1\n")
+
+	a, err := chooseAsset(menuAssets, ini.Empty())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Name != "libtool_1.0_amd64.deb" {
+		t.Errorf("got %s, want libtool_1.0_amd64.deb", a.Name)
+	}
+}
+
+func TestPickAssetsEOF(t *testing.T) {
+	fakeMenuInput(t, "")
+
+	if a, err := chooseAsset(menuAssets, ini.Empty()); err == nil {
+		t.Errorf("got %s, want an error", a.Name)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// like dpkg's lock. held for the whole run of any command that changes state.
+const lockPath = "/etc/yadeb/lock"
+
+// takes the yadeb lock, failing right away if another yadeb has it
+func acquireLock() (*os.File, error) {
+	if err := os.MkdirAll("/etc/yadeb", 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+
+		if err == syscall.EWOULDBLOCK {
+			// the holder writes its pid in the lock file
+			data := make([]byte, 32)
+			n, _ := f.ReadAt(data, 0)
+			if pid := strings.TrimSpace(string(data[:n])); pid != "" {
+				return nil, fmt.Errorf("another yadeb is running (pid %s)", pid)
+			}
+
+			return nil, fmt.Errorf("another yadeb is running")
+		}
+
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// gives the lock back. closing the file is what actually unlocks it.
+func releaseLock(f *os.File) {
+	f.Truncate(0)
+	f.Close()
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 )
 
@@ -12,46 +14,150 @@ var (
 	BuildDate string = "undefined"
 	Version   string = "undefined"
 
-	// supported architectures (GOARCH format) and aliases to all the weird names people give them
+	// supported architectures (debian format) and aliases to all the weird names people give them.
+	// aliases only match as whole words in file names, see nameHasArch.
 	architectureAliases = map[string][]string{
-		"386":     {"i386", "i686", "ia32", "x86"},
-		"amd64":   {"amd64", "x86_64", "x86-64", "x64"},
-		"arm":     {"armhf", "armel", "armv7"}, // TODO: this is bad. armhf != armel. this WILL cause problems later. TODO!!!!
-		"arm64":   {"arm64", "aarch64", "armv8"},
-		"ppc64le": {"ppc64le", "ppc64el"},
-		"riscv64": {"riscv64", "rv64", "risc-v64"},
-		"s390x":   {"s390x"},
+		"amd64":    {"amd64", "x86_64", "x86-64", "x64"},
+		"i386":     {"i386", "i686", "ia32", "x86", "386"},
+		"armhf":    {"armhf", "armv7", "armv7l", "armv7hf"},
+		"armel":    {"armel", "armv5", "armv5te", "armv4t"},
+		"arm64":    {"arm64", "aarch64", "armv8"},
+		"ppc64el":  {"ppc64el", "ppc64le"},
+		"riscv64":  {"riscv64", "rv64", "risc-v64"},
+		"s390x":    {"s390x"},
+		"mips64el": {"mips64el", "mips64le"},
+		"loong64":  {"loong64", "loongarch64"},
 	}
 
-	// all architecture aliases in a single slice
-	allArchitectures []string
+	// never prompt: ambiguous choices go through [yadeb] AssetPreference, and apt gets -y.
+	// set by -y/--non-interactive, or when stdin isn't a terminal.
+	nonInteractive bool
+
+	// where menus read answers from
+	menuInput = bufio.NewReader(os.Stdin)
+
+	// dpkg's architecture and foreign architectures, once asked
+	hostArchitecture     string
+	foreignArchitectures []string
 )
 
 const (
 	// green "done" string
 	doneMsg string = " \033[92mDone\033[0m"
+
+	// exit code for when a choice had to be made, but nobody was there to make it
+	exitAmbiguous = 3
+
+	// exit code for when some links worked and others didn't
+	exitPartial = 4
 )
 
 type (
 	// a tracked "package"
 	Package struct {
-		Package      string
+		Packages     []string // debian packages installed from Link
 		Link         string
 		InstalledTag string
 		InstallDate  string
 		LastUpdate   string
+
+		// url templates only
+		VersionUrl   string `json:",omitempty"`
+		VersionPath  string `json:",omitempty"`
+		VersionRegex string `json:",omitempty"`
+
+		// foreign architecture installed, empty for the native one
+		Architecture string `json:",omitempty"`
+
+		// distro variant of the installed files (like ubuntu22.04), kept on upgrades
+		Distro string `json:",omitempty"`
+
+		// picks the asset to install when the name-based filtering isn't enough
+		AssetPattern string `json:",omitempty"`
+
+		// installed with --file, until the next upgrade
+		LocalFile bool `json:",omitempty"`
+
+		// expected sigstore identity, and who the installed release was verified to be signed by
+		SigstoreIssuer  string `json:",omitempty"`
+		SigstoreSubject string `json:",omitempty"`
+		Provenance      string `json:",omitempty"`
+
+		// the installed files, and what was installed before (oldest first), for rollbacks
+		Assets  []installedAsset `json:",omitempty"`
+		History []historyEntry   `json:",omitempty"`
+	}
+
+	// a file that was installed, so it can be found (and checked) again
+	installedAsset struct {
+		Name   string
+		Url    string // a path, for local files
+		Sha256 string
+		Local  bool `json:",omitempty"`
+	}
+
+	// a release that used to be installed
+	historyEntry struct {
+		Tag         string
+		InstallDate string
+		Assets      []installedAsset `json:",omitempty"`
+		Provenance  string           `json:",omitempty"`
+	}
+
+	// options for commands that change packages
+	RunOptions struct {
+		DryRun   bool // only show the plan
+		Simulate bool // also download the files and ask apt-get what would change
+	}
+
+	// rollback command options
+	RollbackOptions struct {
+		RunOptions
+
+		To string // tag to go back to, empty for the previous one
+	}
+
+	// install command options
+	InstallOptions struct {
+		RunOptions
+
+		Tag     string
+		Version versionRule
+		File    string // local .deb to install instead of downloading
+
+		// what to check File against, when the link's keys or RequireChecksum call for it.
+		// by default they're looked for next to File (foo.deb.asc, foo.deb.sha256).
+		Signature string
+		Sha256    string
+
+		AssetPattern string // regex the whole asset name has to match
+		Arch         string // debian architecture to install, empty for the native one
+
+		Sigstore sigstoreIdentity
 	}
 
 	PackageToInstall struct {
-		Name         string
-		Tag          string
-		DownloadLink string
-		Url          *url.URL
+		Name       string
+		Tag        string
+		Assets     []Asset // one per tracked debian package
+		Distro     string  // distro variant of Assets, if any
+		Provenance string  // verified sigstore subject, once checked
+		FromTag    string  // installed tag, for upgrades
+		Url        *url.URL
+		Mark       *Package // install mark for new packages, nil for upgrades
+
+		Local     bool             // asset urls are paths on disk
+		CacheOnly bool             // local files of an earlier install, which only the cache still has
+		Downgrade bool             // installing an older release is expected
+		Files     []installedAsset // what was installed, once it's downloaded
+		Paths     []string         // where Files were downloaded to
 	}
 )
 
 // entry point
 func main() {
+	os.Args = parseGlobalArgs(os.Args)
+
 	if len(os.Args) <= 1 {
 		helpMenu()
 		os.Exit(2)
@@ -68,16 +174,28 @@ func main() {
 	case "-v", "--version":
 		fmt.Printf("yadeb v%s (built on %s)\n", Version, BuildDate)
 	case "install":
-		tagFlag := fs.String("tag", "latest", "Release/GitHub tag")
+		var opts InstallOptions
+		fs.StringVar(&opts.Tag, "tag", "latest", "Release/GitHub tag")
+		fs.StringVar(&opts.Version.Url, "version-url", "", "URL listing versions, for URL templates")
+		fs.StringVar(&opts.Version.Path, "version-path", "", "Path (gjson syntax) to the version(s) in --version-url's JSON")
+		fs.StringVar(&opts.Version.Regex, "version-regex", "", "Regex whose first group is a version in --version-url's page")
+		fs.StringVar(&opts.AssetPattern, "asset-pattern", "", "Regex the whole asset name has to match, remembered for upgrades")
+		fs.StringVar(&opts.Arch, "arch", "", "Debian architecture to install (native or foreign, see dpkg --print-foreign-architectures)")
+		fs.StringVar(&opts.File, "file", "", "Install this local .deb instead of downloading (requires --tag)")
+		fs.StringVar(&opts.Signature, "signature", "", "Detached signature of --file (default: its .asc, .sig or .gpg next to it)")
+		fs.StringVar(&opts.Sha256, "sha256", "", "SHA-256 of --file (default: from its .sha256 or .sha256sum next to it)")
+		fs.StringVar(&opts.Sigstore.Issuer, "sigstore-issuer", "", "Require sigstore bundles from this OIDC issuer (requires --sigstore-subject)")
+		fs.StringVar(&opts.Sigstore.Subject, "sigstore-subject", "", "Require sigstore bundles whose certificate subject (email or workflow ref) matches this regex (requires --sigstore-issuer)")
+		runFlags(fs, &opts.RunOptions)
 
-		fs.Parse(os.Args[2:])
-		os.Exit(cmdInstall(fs.Args(), *tagFlag))
+		os.Exit(cmdInstall(parseArgs(fs, os.Args[2:]), opts))
 	case "remove", "purge":
-		fs.Parse(os.Args[2:])
-		os.Exit(cmdRemove(fs.Args()))
+		os.Exit(cmdRemove(parseArgs(fs, os.Args[2:])))
 	case "upgrade":
-		fs.Parse(os.Args[2:])
-		os.Exit(cmdUpgrade(fs.Args()))
+		var opts RunOptions
+		runFlags(fs, &opts)
+
+		os.Exit(cmdUpgrade(parseArgs(fs, os.Args[2:]), opts))
 	case "list":
 		pkgs, err := getAllPackages()
 		if err != nil {
@@ -89,21 +207,89 @@ func main() {
 		}
 
 		for _, p := range pkgs {
-			if p.Link == "DEFAULT" {
-				continue
-			}
-
 			shortLink, _ := strings.CutPrefix(p.Link, "https://")
-			fmt.Printf("\033[92m%s\033[0m: %s %s\nInstalled on %s, Last updated on %s\n\n", shortLink, p.Package, p.InstalledTag, p.InstallDate, p.LastUpdate)
+			if l, err := url.PathUnescape(shortLink); err == nil {
+				shortLink = l // url templates
+			}
+			fmt.Printf("\033[92m%s\033[0m: %s %s\nInstalled on %s, Last updated on %s\n", shortLink, strings.Join(p.Packages, ", "), p.InstalledTag, p.InstallDate, p.LastUpdate)
+			if p.Architecture != "" {
+				fmt.Println("Foreign architecture:", p.Architecture)
+			}
+			if p.LocalFile {
+				fmt.Println("Installed from a local file")
+			}
+			if p.Provenance != "" {
+				fmt.Printf("\033[92mProvenance verified\033[0m: signed by %s\n", p.Provenance)
+			}
+			if len(p.History) != 0 {
+				var tags []string
+				for _, h := range p.History {
+					tags = append(tags, h.Tag)
+				}
+				fmt.Println("Previously installed:", strings.Join(tags, ", "))
+			}
+			fmt.Println()
 		}
 	case "upgrade-all":
-		os.Exit(cmdUpgradeAll())
+		var opts RunOptions
+		runFlags(fs, &opts)
+		parseArgs(fs, os.Args[2:])
+
+		os.Exit(cmdUpgradeAll(opts))
+	case "rollback":
+		var opts RollbackOptions
+		fs.StringVar(&opts.To, "to", "", "Tag to roll back to (default: the previously installed one)")
+		runFlags(fs, &opts.RunOptions)
+
+		os.Exit(cmdRollback(parseArgs(fs, os.Args[2:]), opts))
+	case "key":
+		os.Exit(cmdKey(os.Args[2:]))
+	case "cache":
+		os.Exit(cmdCache(os.Args[2:]))
 	default:
 		helpMenu()
 		os.Exit(2)
 	}
 }
 
+// parses flags that can come before, between or after links, and returns the links
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var links []string
+
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return links
+		}
+
+		links = append(links, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// adds the flags of RunOptions
+func runFlags(fs *flag.FlagSet, opts *RunOptions) {
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Only show what would be done")
+	fs.BoolVar(&opts.Simulate, "simulate", false, "Like --dry-run, but also download the files and show what apt-get would change")
+}
+
+// takes global options out of args, wherever they are
+func parseGlobalArgs(args []string) []string {
+	if !stdinIsTerminal() {
+		nonInteractive = true
+	}
+
+	return slices.DeleteFunc(args, func(arg string) bool {
+		switch arg {
+		case "-y", "--non-interactive", "-non-interactive":
+			nonInteractive = true
+			return true
+		}
+
+		return false
+	})
+}
+
 // shows help message
 func helpMenu() {
 	// TODO: maybe use a different word instead of packages?
@@ -116,7 +302,15 @@ func helpMenu() {
 			"  purge - purges packages\n"+
 			"  upgrade - upgrades packages\n"+
 			"  upgrade-all - upgrades all installed packages\n"+
+			"  rollback - reinstalls the previously installed release of packages\n"+
 			"  list - lists installed packages\n"+
+			"  key - imports, lists and removes trusted signing keys\n"+
+			"  cache - lists, cleans (cache clean) and prunes (cache prune) downloaded .debs\n\n"+
+			"Global options:\n"+
+			"  -y, --non-interactive - never ask yadeb's questions, and install without apt's prompt (the default when stdin isn't a terminal).\n"+
+			"    removing still goes through apt's prompt.\n\n"+
+			"Exit codes: 0 success, 1 failure, 2 bad usage, 3 a choice was needed but nobody could make it,\n"+
+			"4 some links failed and others didn't\n"+
 			"For more info about a command, type '%s <command> --help'.\n",
 
 		Version, BuildDate, os.Args[0], os.Args[0],
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/ini.v1"
+)
+
+// network work done ahead of time on up to [yadeb] Concurrency goroutines. the workers don't print anything, they only
+// fill these in for the usual one-by-one pass to find, so output stays in order and menus still work.
+var (
+	prefetchMu sync.Mutex
+
+	// releases by link
+	prefetchedReleases = map[string]releasesResult{}
+
+	// control files by asset url
+	prefetchedControls = map[string]controlResult{}
+
+	// finished (or failed) downloads into partial files, by url
+	prefetchedDownloads = map[string]downloadResult{}
+)
+
+type (
+	releasesResult struct {
+		releases []Release
+		err      error
+	}
+
+	controlResult struct {
+		ctl *debControl
+		err error
+	}
+
+	downloadResult struct {
+		sha256sum string
+		err       error
+	}
+)
+
+// calls work for 0 to n-1 on up to [yadeb] Concurrency goroutines, counting finished jobs on terminals
+func parallel(cfg *ini.File, n int, work func(i int)) {
+	workers := max(1, cfg.Section("yadeb").Key("Concurrency").MustInt(4))
+	tty := stdoutIsTerminal()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		finished int
+	)
+
+	if tty {
+		fmt.Printf("\0337 0/%d", n)
+	}
+
+	jobs := make(chan int)
+	for range min(workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				work(i)
+
+				mu.Lock()
+				finished++
+				if tty {
+					fmt.Printf("\0338\033[K %d/%d", finished, n)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range n {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if tty {
+		fmt.Print("\0338\033[K")
+	}
+}
+
+// fetches the releases of tracked links (and the control files resolveRelease will want) in parallel
+func prefetchReleases(links []string, cfg *ini.File) {
+	type job struct {
+		src  Source
+		u    *url.URL
+		arch string
+	}
+
+	var jobs []job
+	for _, link := range links {
+		u, src, err := parseLink(link)
+		if err != nil {
+			continue
+		}
+
+		p, err := getPackage(u.String())
+		if err != nil || p == nil {
+			continue
+		}
+
+		// the rules map isn't safe to write to once the workers are going
+		if p.VersionUrl != "" {
+			setVersionRule(p.Link, versionRule{p.VersionUrl, p.VersionPath, p.VersionRegex})
+		}
+
+		jobs = append(jobs, job{src, u, packageArch(p)})
+	}
+
+	if len(jobs) < 2 {
+		return
+	}
+
+	sec := cfg.Section("yadeb")
+	depth := sec.Key("ReleaseDepth").MustInt(50)
+	allowPrerelease := sec.Key("AllowPrerelease").MustBool(false)
+
+	apiNoWait = true
+	defer func() { apiNoWait = false }()
+
+	fmt.Printf("Checking %d packages for new releases...", len(jobs))
+	parallel(cfg, len(jobs), func(i int) {
+		releases, err := jobs[i].src.Releases(jobs[i].u, depth)
+
+		// left for resolveRelease, which can wait it out
+		var rle *rateLimitError
+		if errors.As(err, &rle) {
+			return
+		}
+
+		prefetchMu.Lock()
+		prefetchedReleases[jobs[i].u.String()] = releasesResult{releases, err}
+		prefetchMu.Unlock()
+
+		if err == nil {
+			prefetchControls(releases, jobs[i].arch, allowPrerelease)
+		}
+	})
+	fmt.Println(doneMsg)
+}
+
+// reads the control files of the newest allowed release's .debs, if there's more than one to choose from
+func prefetchControls(releases []Release, arch string, allowPrerelease bool) {
+	for _, rel := range releases {
+		if rel.Prerelease && !allowPrerelease {
+			continue
+		}
+
+		var debs []Asset
+		for _, a := range rel.Assets {
+			a.Name = strings.ReplaceAll(a.Name, "{arch}", arch)
+			a.Url = strings.ReplaceAll(a.Url, "{arch}", arch)
+
+			if strings.HasSuffix(a.Name, ".deb") {
+				debs = append(debs, a)
+			}
+		}
+
+		if len(debs) < 2 {
+			return
+		}
+
+		for _, a := range debs {
+			ctl, err := fetchDebControl(a)
+
+			prefetchMu.Lock()
+			prefetchedControls[a.Url] = controlResult{ctl, err}
+			prefetchMu.Unlock()
+		}
+
+		return
+	}
+}
+
+// downloads the assets of pkgs that aren't cached in parallel, into partial files for downloadFile to pick up
+func prefetchDownloads(cfg *ini.File, pkgs []PackageToInstall) {
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		return
+	}
+
+	var (
+		urls []string
+		seen = map[string]bool{}
+	)
+
+	for _, p := range pkgs {
+		if p.Local {
+			continue
+		}
+
+		for _, a := range p.Assets {
+			if seen[a.Url] || isCached(a, p.Tag, cfg) {
+				continue
+			}
+
+			seen[a.Url] = true
+			urls = append(urls, a.Url)
+		}
+	}
+
+	if len(urls) < 2 {
+		return
+	}
+
+	fmt.Printf("Downloading %d files...", len(urls))
+	parallel(cfg, len(urls), func(i int) {
+		sum, err := downloadPart(urls[i], partialPath(urls[i], ""), false)
+
+		prefetchMu.Lock()
+		prefetchedDownloads[urls[i]] = downloadResult{sum, err}
+		prefetchMu.Unlock()
+	})
+	fmt.Println(doneMsg)
+}
+
+// what prefetchReleases got for a link
+func prefetchedReleasesOf(link string) (releasesResult, bool) {
+	prefetchMu.Lock()
+	defer prefetchMu.Unlock()
+
+	r, ok := prefetchedReleases[link]
+	return r, ok
+}
+
+// what prefetchReleases got for an asset's control file
+func prefetchedControlOf(href string) (controlResult, bool) {
+	prefetchMu.Lock()
+	defer prefetchMu.Unlock()
+
+	r, ok := prefetchedControls[href]
+	return r, ok
+}
+
+// what prefetchDownloads got for a url: the file's sha256 or why it failed. it's only used once, later downloads of
+// it start over.
+func takePrefetchedDownload(href string) (string, bool, error) {
+	prefetchMu.Lock()
+	defer prefetchMu.Unlock()
+
+	r, ok := prefetchedDownloads[href]
+	delete(prefetchedDownloads, href)
+	return r.sha256sum, ok, r.err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// prints what would be installed, and with Simulate, what apt-get would do about it. nothing gets marked or installed.
+// indexes are pkgs' places in summary. returns the exit code.
+func showPlan(cfg *ini.File, opts RunOptions, summary *runSummary, pkgs []PackageToInstall, indexes []int) int {
+	if len(pkgs) == 0 {
+		fmt.Println("\nNothing to do")
+		return summary.exitCode()
+	}
+
+	fmt.Println("\nPlan:")
+	for _, p := range pkgs {
+		from := p.FromTag
+		if from == "" {
+			from = "not installed"
+		}
+
+		fmt.Printf("\033[92m%s\033[0m: %s -> %s\n", displayLink(p.Url.String()), from, p.Tag)
+		for _, a := range p.Assets {
+			fmt.Printf("  %s (%s, %s)\n", a.Name, formatSize(a.Size), verificationNote(a))
+		}
+	}
+
+	if opts.Simulate {
+		if err := simulateInstall(cfg, pkgs); err != nil {
+			ansiError("Couldn't simulate:", err.Error())
+			for _, i := range indexes {
+				summary.set(i, err, "")
+			}
+		}
+	}
+
+	return summary.exitCode()
+}
+
+// what an asset will be checked against
+func verificationNote(a Asset) string {
+	var checks []string
+	if a.Checksum != "" {
+		checks = append(checks, "sha256")
+	}
+
+	if a.Signature != "" {
+		checks = append(checks, "signature")
+	}
+
+	if a.Cosign != nil {
+		checks = append(checks, "sigstore")
+	}
+
+	if len(checks) == 0 {
+		return "no checksum"
+	}
+
+	return "checked by " + strings.Join(checks, ", ")
+}
+
+// human-readable file size
+func formatSize(n int64) string {
+	if n <= 0 {
+		return "unknown size"
+	}
+
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// downloads and verifies pkgs into a temp dir, then shows what apt-get would change to install them
+func simulateInstall(cfg *ini.File, pkgs []PackageToInstall) error {
+	tempDir, err := createTempDir()
+	if err != nil {
+		return fmt.Errorf("couldn't create temp directory: %s", err)
+	}
+	defer cleanupDir(tempDir)
+
+	var paths []string
+	for i := range pkgs {
+		p := &pkgs[i]
+		for _, a := range p.Assets {
+			path, _, err := fetchAsset(a, tempDir, p, cfg)
+			if err != nil {
+				return fmt.Errorf("couldn't get %s: %s", a.Name, err)
+			}
+
+			paths = append(paths, path)
+		}
+	}
+
+	fmt.Print("Asking APT what would change...")
+	out, err := exec.Command("apt-get", append([]string{"--simulate", "install"}, paths...)...).CombinedOutput()
+	if err != nil {
+		fmt.Println()
+		fmt.Print(string(out))
+		return fmt.Errorf("apt-get --simulate failed: %s", err)
+	}
+	fmt.Println(doneMsg)
+
+	// Inst/Remv lines are the changes, the rest is noise except for the totals
+	var totals string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Inst "):
+			fmt.Println("  install", strings.TrimPrefix(line, "Inst "))
+		case strings.HasPrefix(line, "Remv "):
+			fmt.Println("  \033[91mremove\033[0m", strings.TrimPrefix(line, "Remv "))
+		case strings.Contains(line, "newly installed"):
+			totals = line
+		}
+	}
+
+	if totals != "" {
+		fmt.Println(totals)
+	}
+
+	return nil
+}
@@ -2,9 +2,7 @@ package main
 
 import (
 	"fmt"
-	"net/url"
 	"os"
-	"strings"
 	"syscall"
 )
 
@@ -20,49 +18,83 @@ func cmdRemove(links []string) int {
 		return 2
 	}
 
-	// "normalize" the url
-	raw := links[0]
-	if !strings.Contains(raw, "://") {
-		raw = "https://" + raw
+	lock, err := acquireLock()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
 	}
+	defer releaseLock(lock)
 
-	// parse link
-	u, err := url.Parse(raw)
-	if err != nil {
-		ansiError("Couldn't parse link:", err.Error())
+	// needed for self-hosted forges
+	if _, err := loadConfig(); err != nil {
+		ansiError(err.Error())
 		return 1
 	}
 
-	// get user and repo
-	pkgName, _ := strings.CutPrefix(u.Path, "/")
+	var (
+		summary runSummary
+		debPkgs []string
+		raws    []string
+		indexes []int // raws' places in summary
+	)
 
-	fmt.Printf("Checking if %s is installed...", pkgName)
-	p, err := getPackage(raw)
-	if err != nil {
-		lnAnsiError("Couldn't read installed package database:", err.Error())
-		return 1
+	for _, link := range links {
+		u, src, err := parseLink(link)
+		if err != nil {
+			ansiError("Invalid link:", err.Error())
+			summary.add(displayLink(link), fmt.Errorf("invalid link: %s", err), "")
+			continue
+		}
+
+		raw := u.String()
+		pkgName := src.PackageName(u)
+
+		fmt.Printf("Checking if %s is installed...", pkgName)
+		p, err := getPackage(raw)
+		if err != nil {
+			lnAnsiError("Couldn't read installed package database:", err.Error())
+			summary.add(displayLink(raw), err, "")
+			continue
+		}
+
+		if p == nil || len(p.Packages) == 0 {
+			lnAnsiError("Requested package isn't installed")
+			summary.add(displayLink(raw), fmt.Errorf("not installed"), "")
+			continue
+		}
+		fmt.Println(doneMsg)
+
+		debPkgs = append(debPkgs, p.Packages...)
+		raws = append(raws, raw)
+		indexes = append(indexes, summary.add(displayLink(raw), nil, ""))
 	}
 
-	if p == nil || p.Package == "" {
-		fmt.Println(p)
-		ansiError("Requested package isn't installed")
-		return 1
+	if len(raws) == 0 {
+		return summary.print()
 	}
-	fmt.Println(doneMsg)
 
-	// actually uninstall
+	// actually uninstall, everything at once
 	fmt.Printf("Starting APT (%s)...\n\n", os.Args[1])
-	if err := runApt(os.Args[1], p.Package); err != nil {
+	if err := runApt(nil, append([]string{os.Args[1]}, debPkgs...)...); err != nil {
 		ansiError("Couldn't run apt:", err.Error())
-		return 1
+		for _, i := range indexes {
+			summary.set(i, fmt.Errorf("couldn't run apt: %s", err), "")
+		}
+
+		return summary.print()
 	}
 
-	fmt.Printf("\n\nRemoving installation mark from %s...", pkgName)
-	if err := unmarkAsInstalled(raw); err != nil {
-		ansiError("Couldn't remove installation mark:", err.Error())
-		return 1
+	for i, raw := range raws {
+		fmt.Printf("\n\nRemoving installation mark from %s...", displayLink(raw))
+		if err := unmarkAsInstalled(raw); err != nil {
+			lnAnsiError("Couldn't remove installation mark:", err.Error())
+			summary.set(indexes[i], fmt.Errorf("removed, but couldn't remove installation mark: %s", err), "")
+			continue
+		}
+		fmt.Println(doneMsg)
+
+		summary.set(indexes[i], nil, os.Args[1]+"d")
 	}
-	fmt.Println(doneMsg)
 
-	return 0
+	return summary.print()
 }
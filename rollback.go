@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"gopkg.in/ini.v1"
+)
+
+// the rollback command
+func cmdRollback(links []string, opts RollbackOptions) int {
+	if len(links) == 0 {
+		ansiError("Nothing to roll back")
+		return 2
+	}
+
+	if opts.To != "" && len(links) != 1 {
+		ansiError("Rolling back to a tag only works with one link")
+		return 2
+	}
+
+	if syscall.Geteuid() != 0 {
+		ansiError("Rolling back requires root privileges")
+		return 2
+	}
+
+	lock, err := acquireLock()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+	defer releaseLock(lock)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	var (
+		summary runSummary
+		pkgs    []PackageToInstall
+		indexes []int // pkgs' places in summary
+	)
+
+	for _, link := range links {
+		pii, err := resolveRollback(link, opts.To, cfg)
+		if err != nil {
+			ansiError(err.Error())
+			summary.add(displayLink(link), err, "")
+			continue
+		}
+
+		indexes = append(indexes, summary.add(displayLink(pii.Url.String()), nil, ""))
+		pkgs = append(pkgs, *pii)
+	}
+
+	if opts.DryRun || opts.Simulate {
+		return showPlan(cfg, opts.RunOptions, &summary, pkgs, indexes)
+	}
+
+	if len(pkgs) != 0 {
+		for i, err := range installCandidates(cfg, pkgs...) {
+			summary.set(indexes[i], err, "rolled back to "+pkgs[i].Tag)
+		}
+	}
+
+	return summary.print()
+}
+
+// finds what to reinstall to roll a link back to tag, or to the previous release if tag is empty
+func resolveRollback(link, tag string, cfg *ini.File) (*PackageToInstall, error) {
+	u, src, err := parseLink(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link: %s", err)
+	}
+
+	p, err := getPackage(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read installed package database: %s", err)
+	}
+	if p == nil {
+		return nil, fmt.Errorf("%s isn't installed", displayLink(u.String()))
+	}
+
+	if len(p.History) == 0 {
+		return nil, fmt.Errorf("%s has no previous release to go back to", displayLink(u.String()))
+	}
+
+	// newest first
+	var entry *historyEntry
+	for i := len(p.History) - 1; i >= 0; i-- {
+		if tag == "" || p.History[i].Tag == tag {
+			entry = &p.History[i]
+			break
+		}
+	}
+
+	if entry == nil {
+		var tags []string
+		for _, h := range p.History {
+			tags = append(tags, h.Tag)
+		}
+
+		return nil, fmt.Errorf("%s was never installed at %s (previous releases: %s)", displayLink(u.String()), tag, strings.Join(tags, ", "))
+	}
+
+	pii := &PackageToInstall{
+		Name:       src.PackageName(u),
+		Tag:        entry.Tag,
+		FromTag:    p.InstalledTag,
+		Distro:     p.Distro,
+		Provenance: entry.Provenance,
+		Url:        u,
+		Downgrade:  true,
+	}
+
+	// the same files as back then, which the checksums make sure of. local ones can only come from the cache.
+	if len(entry.Assets) != 0 {
+		for _, a := range entry.Assets {
+			asset := Asset{Name: a.Name, Url: a.Url, Checksum: a.Sha256}
+			if a.Local {
+				if !isCached(asset, entry.Tag, cfg) {
+					return nil, fmt.Errorf("%s at %s was installed from a local file (%s) that isn't cached anymore", displayLink(u.String()), entry.Tag, a.Url)
+				}
+
+				pii.CacheOnly = true
+			}
+
+			pii.Assets = append(pii.Assets, asset)
+		}
+
+		return pii, nil
+	}
+
+	// releases installed before files were recorded have to be looked up again
+	if p.VersionUrl != "" {
+		setVersionRule(p.Link, versionRule{p.VersionUrl, p.VersionPath, p.VersionRegex})
+	}
+
+	rel, candidates, err := resolveRelease(src, u, entry.Tag, packageArch(p), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if pii.Assets, err = upgradeAssets(rel, candidates, p, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := prepareAssets(rel, pii.Assets, u.String(), sigstoreIdentity{p.SigstoreIssuer, p.SigstoreSubject}, cfg); err != nil {
+		return nil, err
+	}
+
+	return pii, nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// per-package trusted keys live at /etc/yadeb/keys/<host>/<path>.gpg.
+// a package with keys is pinned: every release has to be signed by one of them.
+const keysDir = "/etc/yadeb/keys"
+
+// where a package's keyring lives
+func keyringPath(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(keysDir, u.Host, filepath.Clean("/"+u.Path)) + ".gpg", nil
+}
+
+// loads a package's keyring. no keyring means nil, nil.
+func loadKeyring(link string) (openpgp.EntityList, error) {
+	path, err := keyringPath(link)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return readKeys(data)
+}
+
+// reads armored or binary public keys
+func readKeys(data []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// the signature assets that might cover name
+func signatureNames(name string) []string {
+	return []string{name + ".asc", name + ".sig", name + ".gpg"}
+}
+
+// finds a release asset by name
+func findAsset(rel *Release, name string) (Asset, bool) {
+	for _, a := range rel.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+
+	return Asset{}, false
+}
+
+// if link is pinned to keys, finds how c is signed. a detached signature of c itself is stored in c.Signature and
+// checked after downloading, a signed checksum file is checked right away and its hash replaces c.Checksum.
+func findSignature(rel *Release, c *Asset, link string) error {
+	keyring, err := loadKeyring(link)
+	if err != nil {
+		return fmt.Errorf("couldn't read trusted keys: %s", err)
+	}
+
+	if keyring == nil {
+		return nil
+	}
+
+	// signature of the .deb itself
+	for _, name := range signatureNames(c.Name) {
+		if sig, ok := findAsset(rel, name); ok {
+			c.Signature = sig.Url
+			return nil
+		}
+	}
+
+	// signed checksum file
+	for _, a := range rel.Assets {
+		perFile := a.Name == c.Name+".sha256" || a.Name == c.Name+".sha256sum"
+		if !perFile && !checksumFileRegex.MatchString(a.Name) {
+			continue
+		}
+
+		for _, name := range signatureNames(a.Name) {
+			sig, ok := findAsset(rel, name)
+			if !ok {
+				continue
+			}
+
+			fmt.Printf("Verifying signature of %s...", a.Name)
+			sums, err := fetchBytes(a.Url, 1<<20)
+			if err != nil {
+				fmt.Println()
+				return fmt.Errorf("couldn't download %s: %s", a.Name, err)
+			}
+
+			signer, err := verifySignature(keyring, bytes.NewReader(sums), sig.Url)
+			if err != nil {
+				fmt.Println()
+				return fmt.Errorf("%s: %s", a.Name, err)
+			}
+			fmt.Printf(" \033[92mSigned by %s\033[0m\n", keyName(signer))
+
+			sum, err := parseChecksums(bytes.NewReader(sums), c.Name, perFile)
+			if err != nil {
+				return err
+			}
+
+			if sum == "" {
+				continue
+			}
+
+			if c.Checksum != "" && c.Checksum != sum {
+				return fmt.Errorf("signed checksum of %s doesn't match the unsigned one", c.Name)
+			}
+
+			c.Checksum = sum
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is pinned to trusted keys, but release %s has no signature for %s", link, rel.Tag, c.Name)
+}
+
+// checks a downloaded file against its detached signature
+func verifyFileSignature(path, sigUrl, link string) error {
+	keyring, err := loadKeyring(link)
+	if err != nil {
+		return fmt.Errorf("couldn't read trusted keys: %s", err)
+	}
+
+	if keyring == nil {
+		return fmt.Errorf("%s has a signature to check, but no trusted keys", link)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("Verifying signature of %s...", filepath.Base(path))
+	signer, err := verifySignature(keyring, f, sigUrl)
+	if err != nil {
+		fmt.Println()
+		return err
+	}
+	fmt.Printf(" \033[92mSigned by %s\033[0m\n", keyName(signer))
+
+	return nil
+}
+
+// downloads (or reads, for local files) a detached signature and checks signed against it
+func verifySignature(keyring openpgp.EntityList, signed io.Reader, sigUrl string) (*openpgp.Entity, error) {
+	var (
+		sig []byte
+		err error
+	)
+
+	if strings.Contains(sigUrl, "://") {
+		sig, err = fetchBytes(sigUrl, 1<<20)
+	} else {
+		sig, err = os.ReadFile(sigUrl)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download signature: %s", err)
+	}
+
+	var signer *openpgp.Entity
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keyring, signed, bytes.NewReader(sig), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keyring, signed, bytes.NewReader(sig), nil)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("bad signature (or signed by an untrusted key): %s", err)
+	}
+
+	return signer, nil
+}
+
+// downloads something small into memory
+func fetchBytes(href string, limit int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", href, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorizeRequest(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// fingerprint and primary uid of a key
+func keyName(e *openpgp.Entity) string {
+	name := strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint))
+
+	if id := e.PrimaryIdentity(); id != nil {
+		name += " (" + id.Name + ")"
+	}
+
+	return name
+}
+
+// the key command
+func cmdKey(args []string) int {
+	if len(args) == 0 {
+		ansiError("Usage: key import <link> <key file> | key list [link] | key remove <link>")
+		return 2
+	}
+
+	switch args[0] {
+	case "import":
+		if len(args) != 3 {
+			ansiError("Usage: key import <link> <key file>")
+			return 2
+		}
+
+		return keyImport(args[1], args[2])
+	case "list":
+		return keyList(args[1:])
+	case "remove":
+		if len(args) != 2 {
+			ansiError("Usage: key remove <link>")
+			return 2
+		}
+
+		return keyRemove(args[1])
+	default:
+		ansiError("Unknown key command:", args[0])
+		return 2
+	}
+}
+
+// adds the keys in file to a package's trusted keys
+func keyImport(link, file string) int {
+	if syscall.Geteuid() != 0 {
+		ansiError("Importing keys requires root privileges")
+		return 2
+	}
+
+	lock, err := acquireLock()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+	defer releaseLock(lock)
+
+	if _, err := loadConfig(); err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	u, _, err := parseLink(link)
+	if err != nil {
+		ansiError("Invalid link:", err.Error())
+		return 2
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		ansiError("Couldn't read key file:", err.Error())
+		return 1
+	}
+
+	keys, err := readKeys(data)
+	if err != nil || len(keys) == 0 {
+		ansiError("Couldn't read keys from", file)
+		return 1
+	}
+
+	existing, err := loadKeyring(u.String())
+	if err != nil {
+		ansiError("Couldn't read trusted keys:", err.Error())
+		return 1
+	}
+
+	// merge, skipping keys we already trust
+	keyring := existing
+	for _, k := range keys {
+		dupe := false
+		for _, e := range existing {
+			if bytes.Equal(e.PrimaryKey.Fingerprint, k.PrimaryKey.Fingerprint) {
+				dupe = true
+				break
+			}
+		}
+
+		if !dupe {
+			keyring = append(keyring, k)
+			fmt.Printf("Trusting %s for %s\n", keyName(k), u.String())
+		}
+	}
+
+	path, err := keyringPath(u.String())
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	var buf bytes.Buffer
+	for _, k := range keyring {
+		if err := k.Serialize(&buf); err != nil {
+			ansiError("Couldn't serialize key:", err.Error())
+			return 1
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		ansiError("Couldn't create key directory:", err.Error())
+		return 1
+	}
+
+	if err := writeFileAtomic(path, 0644, func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}); err != nil {
+		ansiError("Couldn't save keys:", err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+// lists trusted keys, for every package or just the given ones
+func keyList(links []string) int {
+	var paths []string
+
+	if len(links) == 0 {
+		err := filepath.WalkDir(keysDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.IsDir() && strings.HasSuffix(path, ".gpg") {
+				paths = append(paths, path)
+			}
+
+			return nil
+		})
+
+		if err != nil && !os.IsNotExist(err) {
+			ansiError("Couldn't read", keysDir+":", err.Error())
+			return 1
+		}
+	} else {
+		if _, err := loadConfig(); err != nil {
+			ansiError(err.Error())
+			return 1
+		}
+
+		for _, l := range links {
+			u, _, err := parseLink(l)
+			if err != nil {
+				ansiError("Invalid link:", err.Error())
+				return 2
+			}
+
+			path, _ := keyringPath(u.String())
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			ansiError(err.Error())
+			continue
+		}
+
+		keys, err := readKeys(data)
+		if err != nil {
+			ansiError(fmt.Sprintf("Couldn't read %s: %s", path, err))
+			continue
+		}
+
+		link := strings.TrimSuffix(strings.TrimPrefix(path, keysDir+"/"), ".gpg")
+		fmt.Printf("\033[92m%s\033[0m:\n", link)
+		for _, k := range keys {
+			fmt.Println(" ", keyName(k))
+		}
+		fmt.Println()
+	}
+
+	return 0
+}
+
+// unpins a package
+func keyRemove(link string) int {
+	if syscall.Geteuid() != 0 {
+		ansiError("Removing keys requires root privileges")
+		return 2
+	}
+
+	lock, err := acquireLock()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+	defer releaseLock(lock)
+
+	if _, err := loadConfig(); err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	u, _, err := parseLink(link)
+	if err != nil {
+		ansiError("Invalid link:", err.Error())
+		return 2
+	}
+
+	path, err := keyringPath(u.String())
+	if err != nil {
+		ansiError(err.Error())
+		return 1
+	}
+
+	if err := os.Remove(path); err != nil {
+		ansiError("Couldn't remove trusted keys:", err.Error())
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/ini.v1"
+)
+
+type (
+	// who a package's releases have to be signed by
+	sigstoreIdentity struct {
+		Issuer  string // oidc issuer, like https://token.actions.githubusercontent.com
+		Subject string // regex for the certificate subject (email, or workflow ref for ci)
+	}
+
+	// sigstore material for an asset: a bundle, or a signature and certificate
+	cosignFiles struct {
+		Bundle string
+		Sig    string
+		Cert   string
+
+		Identity sigstoreIdentity
+	}
+)
+
+var (
+	// fulcio certificate extensions
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1} // raw string
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8} // der utf8string
+)
+
+// if the package expects a sigstore identity, finds the bundle (or .sig + .pem) of c and stores it in c.Cosign
+func findSigstore(rel *Release, c *Asset, id sigstoreIdentity) error {
+	if id.Issuer == "" && id.Subject == "" {
+		return nil
+	}
+
+	for _, ext := range []string{".sigstore.json", ".sigstore", ".bundle"} {
+		if b, ok := findAsset(rel, c.Name+ext); ok {
+			c.Cosign = &cosignFiles{Bundle: b.Url, Identity: id}
+			return nil
+		}
+	}
+
+	if sig, ok := findAsset(rel, c.Name+".sig"); ok {
+		for _, ext := range []string{".pem", ".crt", ".cert"} {
+			if cert, ok := findAsset(rel, c.Name+ext); ok {
+				c.Cosign = &cosignFiles{Sig: sig.Url, Cert: cert.Url, Identity: id}
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("release %s has no sigstore bundle for %s, but the package expects one", rel.Tag, c.Name)
+}
+
+// verifies a downloaded file's sigstore material against the trusted root in config.ini.
+// returns the verified subject.
+func verifySigstore(path string, files *cosignFiles, cfg *ini.File) (string, error) {
+	fmt.Printf("Verifying sigstore bundle of %s...", filepath.Base(path))
+	subject, logged, err := checkSigstore(path, files, cfg)
+	if err != nil {
+		fmt.Println()
+		return "", err
+	}
+
+	if logged {
+		fmt.Printf(" \033[92mSigned by %s\033[0m\n", subject)
+	} else {
+		fmt.Printf(" \033[92mSigned by %s\033[0m \033[93m(not in a checked transparency log, so the certificate's expiry isn't enforced)\033[0m\n", subject)
+	}
+
+	return subject, nil
+}
+
+// verifySigstore without the output. also returns whether the signature was checked against the transparency log,
+// which is only skipped when RequireTlog is false.
+func checkSigstore(path string, files *cosignFiles, cfg *ini.File) (string, bool, error) {
+	sec := cfg.Section("sigstore")
+
+	roots, intermediates, err := loadTrustedRoot(sec.Key("TrustedRoot").MustString("/etc/yadeb/sigstore/fulcio.pem"))
+	if err != nil {
+		return "", false, fmt.Errorf("couldn't load trusted root: %s", err)
+	}
+
+	// hash the file
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", false, err
+	}
+	digest := hash.Sum(nil)
+
+	// get certificate, signature and (maybe) transparency log entry
+	var (
+		certs []*x509.Certificate
+		sig   []byte
+		entry *tlogEntry
+	)
+
+	if files.Bundle != "" {
+		data, err := fetchBytes(files.Bundle, 1<<20)
+		if err != nil {
+			return "", false, fmt.Errorf("couldn't download bundle: %s", err)
+		}
+
+		certs, sig, entry, err = parseBundle(data)
+		if err != nil {
+			return "", false, fmt.Errorf("couldn't read bundle: %s", err)
+		}
+	} else {
+		sigData, err := fetchBytes(files.Sig, 1<<20)
+		if err != nil {
+			return "", false, fmt.Errorf("couldn't download signature: %s", err)
+		}
+
+		certData, err := fetchBytes(files.Cert, 1<<20)
+		if err != nil {
+			return "", false, fmt.Errorf("couldn't download certificate: %s", err)
+		}
+
+		if sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sigData))); err != nil {
+			return "", false, fmt.Errorf("couldn't decode signature: %s", err)
+		}
+
+		if certs, err = parseCertificates(certData); err != nil {
+			return "", false, fmt.Errorf("couldn't read certificate: %s", err)
+		}
+	}
+
+	if len(certs) == 0 {
+		return "", false, fmt.Errorf("no certificate found")
+	}
+	leaf := certs[0]
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	// fulcio certificates live for minutes, so the chain has to be checked at signing time, which only the
+	// transparency log can vouch for. without it (if RequireTlog allows that) the certificate's own start is used,
+	// where it's always valid.
+	signedAt := leaf.NotBefore
+	key := sec.Key("RekorKey").MustString("/etc/yadeb/sigstore/rekor.pub")
+	logged := entry != nil && fileExists(key)
+
+	if logged {
+		if err := entry.verify(key, digest, sig, leaf); err != nil {
+			return "", false, fmt.Errorf("transparency log entry: %s", err)
+		}
+
+		signedAt = time.Unix(entry.IntegratedTime, 0)
+	} else if sec.Key("RequireTlog").MustBool(true) {
+		if entry == nil {
+			return "", false, fmt.Errorf("no transparency log entry, and RequireTlog is true")
+		}
+
+		return "", false, fmt.Errorf("can't check the transparency log entry without rekor's key (%s), and RequireTlog is true", key)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return "", false, fmt.Errorf("untrusted certificate: %s", err)
+	}
+
+	subject, err := checkIdentity(leaf, files.Identity)
+	if err != nil {
+		return "", false, err
+	}
+
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return "", false, fmt.Errorf("unsupported certificate key type %T", leaf.PublicKey)
+	}
+
+	if !ecdsa.VerifyASN1(pub, digest, sig) {
+		return "", false, fmt.Errorf("bad signature")
+	}
+
+	return subject, logged, nil
+}
+
+// makes sure a certificate was issued by the expected issuer to the expected subject, returning the subject
+func checkIdentity(cert *x509.Certificate, id sigstoreIdentity) (string, error) {
+	if id.Issuer == "" || id.Subject == "" {
+		return "", fmt.Errorf("the package's sigstore identity needs both an issuer and a subject (reinstall it with --sigstore-issuer and --sigstore-subject)")
+	}
+
+	var issuer string
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			asn1.Unmarshal(ext.Value, &issuer)
+		case ext.Id.Equal(oidIssuerV1) && issuer == "":
+			issuer = string(ext.Value)
+		}
+	}
+
+	if issuer != id.Issuer {
+		return "", fmt.Errorf("certificate issued by %q, expected %q", issuer, id.Issuer)
+	}
+
+	var subjects []string
+	for _, u := range cert.URIs {
+		subjects = append(subjects, u.String())
+	}
+	subjects = append(subjects, cert.EmailAddresses...)
+
+	if len(subjects) == 0 {
+		return "", fmt.Errorf("certificate has no subject")
+	}
+
+	re, err := regexp.Compile("^(?:" + id.Subject + ")$")
+	if err != nil {
+		return "", fmt.Errorf("bad expected subject: %s", err)
+	}
+
+	for _, s := range subjects {
+		if re.MatchString(s) {
+			return s, nil
+		}
+	}
+
+	return "", fmt.Errorf("certificate subject %q doesn't match %q", subjects[0], id.Subject)
+}
+
+// reads root and intermediate certificates from a pem file
+func loadTrustedRoot(path string) (*x509.CertPool, *x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) {
+			roots.AddCert(c)
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+
+	return roots, intermediates, nil
+}
+
+// reads pem certificates, which cosign sometimes base64-encodes again
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("not pem or base64 pem")
+		}
+		data = decoded
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, c)
+	}
+
+	return certs, nil
+}
+
+// rekor's promise that it logged a signature
+type tlogEntry struct {
+	Body           string // base64 of the canonicalized entry
+	IntegratedTime int64
+	LogID          string // hex
+	LogIndex       int64
+	SET            []byte // signed entry timestamp
+}
+
+// checks the signed entry timestamp against rekor's key, and that the entry is about our file, signature and
+// certificate. anything less and some other logged signature could vouch for when ours was made.
+func (e *tlogEntry) verify(keyPath string, digest, sig []byte, leaf *x509.Certificate) error {
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return fmt.Errorf("%s isn't a pem public key", keyPath)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported rekor key type %T", key)
+	}
+
+	// canonical json: sorted keys, no whitespace. all values are base64/hex or numbers, so %q is safe.
+	payload := fmt.Sprintf(`{"body":%q,"integratedTime":%d,"logID":%q,"logIndex":%d}`, e.Body, e.IntegratedTime, e.LogID, e.LogIndex)
+	sum := sha256.Sum256([]byte(payload))
+	if !ecdsa.VerifyASN1(pub, sum[:], e.SET) {
+		return fmt.Errorf("bad signed entry timestamp")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return err
+	}
+
+	if logged := gjson.GetBytes(body, "spec.data.hash.value").String(); logged != hex.EncodeToString(digest) {
+		return fmt.Errorf("logged entry is for a different file")
+	}
+
+	loggedSig, err := base64.StdEncoding.DecodeString(gjson.GetBytes(body, "spec.signature.content").String())
+	if err != nil || !bytes.Equal(loggedSig, sig) {
+		return fmt.Errorf("logged entry is for a different signature")
+	}
+
+	loggedCerts, err := parseCertificates([]byte(gjson.GetBytes(body, "spec.signature.publicKey.content").String()))
+	if err != nil || len(loggedCerts) == 0 || !loggedCerts[0].Equal(leaf) {
+		return fmt.Errorf("logged entry is for a different certificate")
+	}
+
+	return nil
+}
+
+// reads a cosign bundle (cosign sign-blob --bundle) or a sigstore bundle (.sigstore.json)
+func parseBundle(data []byte) ([]*x509.Certificate, []byte, *tlogEntry, error) {
+	b := gjson.ParseBytes(data)
+
+	// old cosign format
+	if b.Get("base64Signature").Exists() {
+		sig, err := base64.StdEncoding.DecodeString(b.Get("base64Signature").String())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		certs, err := parseCertificates([]byte(b.Get("cert").String()))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		var entry *tlogEntry
+		if p := b.Get("rekorBundle.Payload"); p.Exists() {
+			set, err := base64.StdEncoding.DecodeString(b.Get("rekorBundle.SignedEntryTimestamp").String())
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			entry = &tlogEntry{
+				Body:           p.Get("body").String(),
+				IntegratedTime: p.Get("integratedTime").Int(),
+				LogID:          p.Get("logID").String(),
+				LogIndex:       p.Get("logIndex").Int(),
+				SET:            set,
+			}
+		}
+
+		return certs, sig, entry, nil
+	}
+
+	// sigstore bundle
+	sig, err := base64.StdEncoding.DecodeString(b.Get("messageSignature.signature").String())
+	if err != nil || len(sig) == 0 {
+		return nil, nil, nil, fmt.Errorf("no message signature (dsse bundles aren't supported)")
+	}
+
+	var raw []gjson.Result
+	if c := b.Get("verificationMaterial.certificate.rawBytes"); c.Exists() {
+		raw = append(raw, c)
+	} else {
+		raw = b.Get("verificationMaterial.x509CertificateChain.certificates.#.rawBytes").Array()
+	}
+
+	var certs []*x509.Certificate
+	for _, r := range raw {
+		der, err := base64.StdEncoding.DecodeString(r.String())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		certs = append(certs, c)
+	}
+
+	var entry *tlogEntry
+	if t := b.Get("verificationMaterial.tlogEntries.0"); t.Get("inclusionPromise").Exists() {
+		set, err := base64.StdEncoding.DecodeString(t.Get("inclusionPromise.signedEntryTimestamp").String())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		logID, err := base64.StdEncoding.DecodeString(t.Get("logId.keyId").String())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		entry = &tlogEntry{
+			Body:           t.Get("canonicalizedBody").String(),
+			IntegratedTime: t.Get("integratedTime").Int(),
+			LogID:          hex.EncodeToString(logID),
+			LogIndex:       t.Get("logIndex").Int(),
+			SET:            set,
+		}
+	}
+
+	return certs, sig, entry, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+type (
+	// a downloadable release file
+	Asset struct {
+		Name string // file name
+		Url  string // download link
+		Size int64  // in bytes, 0 if the source doesn't say
+
+		Checksum  string       // expected sha256 (hex), empty if unknown
+		Signature string       // link to a detached signature of the file, empty if there's none to check
+		Cosign    *cosignFiles // sigstore material, nil if there's none to check
+
+		Control *debControl // read from the .deb itself, nil if it wasn't needed
+	}
+
+	// a release of a package
+	Release struct {
+		Tag        string
+		Prerelease bool
+		Assets     []Asset
+	}
+
+	// somewhere packages come from, like a forge. each file that implements one registers it in init().
+	Source interface {
+		// reads the source's settings (tokens, extra hosts) from config.ini
+		Configure(cfg *ini.File) error
+
+		// returns the canonical form of a link, which is what gets tracked
+		Normalize(u *url.URL) (*url.URL, error)
+
+		// human-readable name of a normalized link, like owner/repo
+		PackageName(u *url.URL) string
+
+		// lists up to depth releases, newest first
+		Releases(u *url.URL, depth int) ([]Release, error)
+
+		// gets a single release by tag
+		ReleaseByTag(u *url.URL, tag string) (*Release, error)
+
+		// adds credentials to a request aimed at this source
+		Authorize(req *http.Request)
+	}
+)
+
+var (
+	// every registered source, in registration order
+	allSources []Source
+
+	// sources keyed by host or scheme
+	sourcesByKey = map[string]Source{}
+)
+
+// registers a source under hosts and/or schemes
+func registerSource(s Source, keys ...string) {
+	allSources = append(allSources, s)
+	addSourceKeys(s, keys...)
+}
+
+// makes more hosts/schemes point to an already registered source
+func addSourceKeys(s Source, keys ...string) {
+	for _, k := range keys {
+		sourcesByKey[k] = s
+	}
+}
+
+// reads the tokens of a forge with a public default host. $env, [section] Token and TokenFile are for defaultHost,
+// other hosts get theirs from [section "host"] Token or TokenFile. a token only ever goes to its own host.
+func readHostTokens(cfg *ini.File, section, env, defaultHost string, hosts []string) (map[string]string, error) {
+	tokens := map[string]string{}
+
+	t, err := readToken(cfg.Section(section), os.Getenv(env), "/etc/yadeb/"+section+"-token")
+	if err != nil {
+		return nil, err
+	}
+
+	if t != "" {
+		tokens[defaultHost] = t
+	}
+
+	for _, h := range hosts {
+		sec, err := cfg.GetSection(fmt.Sprintf("%s %q", section, h))
+		if err != nil {
+			continue // anonymous
+		}
+
+		t, err := readToken(sec, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", h, err)
+		}
+
+		if t != "" {
+			tokens[h] = t
+		}
+	}
+
+	return tokens, nil
+}
+
+// a token from env, the section's Token (only if config.ini is private) or its TokenFile, in that order
+func readToken(sec *ini.Section, env, defaultFile string) (string, error) {
+	if t := strings.TrimSpace(env); t != "" {
+		return t, nil
+	}
+
+	if t := strings.TrimSpace(sec.Key("Token").String()); t != "" {
+		if err := checkSecretFile("/etc/yadeb/config.ini"); err != nil {
+			return "", fmt.Errorf("not using [%s] Token: %s", sec.Name(), err)
+		}
+
+		return t, nil
+	}
+
+	path := sec.Key("TokenFile").MustString(defaultFile)
+	if path == "" {
+		return "", nil
+	}
+
+	return readTokenFile(path)
+}
+
+// lets every source read config.ini
+func configureSources(cfg *ini.File) error {
+	for _, s := range allSources {
+		if err := s.Configure(cfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finds the source a link belongs to, by host and then by scheme
+func sourceFor(u *url.URL) (Source, error) {
+	if s, ok := sourcesByKey[u.Host]; ok {
+		return s, nil
+	}
+
+	if s, ok := sourcesByKey[u.Scheme]; ok {
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("unknown source domain: %s", u.Host)
+}
+
+// parses a user-supplied link, finds its source and normalizes it
+func parseLink(raw string) (*url.URL, Source, error) {
+	// "common hack"
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't parse link: %s", err)
+	}
+
+	// error out if unknown scheme
+	if _, ok := sourcesByKey[u.Scheme]; !ok && !slices.Contains([]string{"https", ""}, u.Scheme) {
+		return nil, nil, fmt.Errorf("unknown source scheme: %s", u.Scheme)
+	}
+
+	src, err := sourceFor(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err = src.Normalize(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, src, nil
+}
+
+// adds credentials to requests aimed at a known source
+func authorizeRequest(req *http.Request) {
+	if s, ok := sourcesByKey[req.URL.Host]; ok {
+		s.Authorize(req)
+	}
+}
+
+// finds the release to install and its candidates for arch. tag is "latest" for the newest allowed release.
+func resolveRelease(src Source, u *url.URL, tag, arch string, cfg *ini.File) (*Release, []Asset, error) {
+	name := u.Host + "/" + src.PackageName(u)
+
+	if tag == "latest" {
+		fmt.Printf("Fetching releases from %s...", name)
+		releases, err := fetchReleases(src, u, cfg)
+		if err != nil {
+			fmt.Println() // Yes, this is bad. Yes, you will see this a lot.
+			return nil, nil, fmt.Errorf("couldn't fetch releases: %s", err)
+		}
+		fmt.Println(doneMsg)
+
+		if len(releases) == 0 {
+			return nil, nil, fmt.Errorf("requested package has no releases available")
+		}
+
+		return latestValidRelease(releases, arch, cfg)
+	}
+
+	fmt.Printf("Fetching %s at release %s...", name, tag)
+	rel, err := src.ReleaseByTag(u, tag)
+	if err != nil {
+		fmt.Println()
+
+		var se *apiStatusError
+		if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+			return nil, nil, fmt.Errorf("release %s: not found", tag)
+		}
+
+		return nil, nil, fmt.Errorf("release %s: failed to fetch: %s", tag, err.Error())
+	}
+	fmt.Println(doneMsg)
+
+	candidates, err := releaseCandidates(rel, arch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("release %s: %s", tag, err.Error())
+	}
+
+	return rel, candidates, nil
+}
+
+// a link's releases, from prefetchReleases if it got them
+func fetchReleases(src Source, u *url.URL, cfg *ini.File) ([]Release, error) {
+	if r, ok := prefetchedReleasesOf(u.String()); ok {
+		return r.releases, r.err
+	}
+
+	return src.Releases(u, cfg.Section("yadeb").Key("ReleaseDepth").MustInt(50))
+}
+
+// finds the newest release that's allowed and has installable candidates
+func latestValidRelease(releases []Release, arch string, cfg *ini.File) (*Release, []Asset, error) {
+	for i, rel := range releases {
+		if !cfg.Section("yadeb").Key("AllowPrerelease").MustBool(false) && rel.Prerelease {
+			fmt.Printf("Skipping release %s: \033[91mrelease is a prerelease, which is disallowed\033[0m\n", rel.Tag)
+			continue
+		}
+
+		candidates, err := releaseCandidates(&rel, arch)
+		if err != nil {
+			fmt.Printf("Skipping release %s: \033[91m%s\033[0m\n", rel.Tag, err.Error())
+			continue
+		}
+
+		return &releases[i], candidates, nil
+	}
+
+	return nil, nil, fmt.Errorf("no valid release found")
+}
+
+// filters a release's assets down to installable candidates for arch
+func releaseCandidates(rel *Release, arch string) ([]Asset, error) {
+	if len(rel.Assets) == 0 {
+		return nil, fmt.Errorf("no assets available")
+	}
+
+	// url templates leave {arch} for us
+	for i := range rel.Assets {
+		rel.Assets[i].Name = strings.ReplaceAll(rel.Assets[i].Name, "{arch}", arch)
+		rel.Assets[i].Url = strings.ReplaceAll(rel.Assets[i].Url, "{arch}", arch)
+	}
+
+	return filterCandidates(slices.Clone(rel.Assets), arch)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// where tracked packages are kept
+	statePath = "/var/lib/yadeb/state.json"
+
+	// where they were kept before state.json. migrated on first use.
+	legacyStatePath = "/etc/yadeb/installed.ini"
+
+	// how many previously installed releases are remembered per package
+	maxHistory = 20
+
+	// state.json schema version. bump it and add a migration when the format changes.
+	stateSchema = 2
+)
+
+// the state database. get it with loadState, change it, then Save it (while holding the lock).
+type State struct {
+	Schema   int       `json:"schema"`
+	Packages []Package `json:"packages"`
+
+	// legacy file to retire once this state is saved
+	migratedFrom string
+}
+
+// upgrades raw state.json data from schema N to N+1
+var stateMigrations = map[int]func(raw map[string]any) error{
+	// 1 -> 2: Package (one debian package per link) became Packages
+	1: func(raw map[string]any) error {
+		pkgs, _ := raw["packages"].([]any)
+		for _, v := range pkgs {
+			p, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("package entry isn't an object")
+			}
+
+			if name, _ := p["Package"].(string); name != "" {
+				p["Packages"] = []any{name}
+			}
+			delete(p, "Package")
+		}
+
+		return nil
+	},
+}
+
+// loads the state database, migrating older formats. no database means an empty one.
+func loadState() (*State, error) {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if fileExists(legacyStatePath) {
+			return migrateLegacyState()
+		}
+
+		return &State{Schema: stateSchema}, nil
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", statePath, err)
+	}
+
+	schema, _ := raw["schema"].(float64)
+	if int(schema) > stateSchema {
+		return nil, fmt.Errorf("%s is from a newer yadeb (schema %d, this one knows %d)", statePath, int(schema), stateSchema)
+	}
+
+	for v := int(schema); v < stateSchema; v++ {
+		migrate, ok := stateMigrations[v]
+		if !ok {
+			return nil, fmt.Errorf("don't know how to migrate %s from schema %d", statePath, v)
+		}
+
+		if err := migrate(raw); err != nil {
+			return nil, fmt.Errorf("couldn't migrate %s from schema %d: %s", statePath, v, err)
+		}
+		raw["schema"] = v + 1
+	}
+
+	// round trip through the migrated data
+	if data, err = json.Marshal(raw); err != nil {
+		return nil, err
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", statePath, err)
+	}
+
+	return &s, nil
+}
+
+// reads installed.ini into a State. it's only replaced on disk when the state is saved.
+func migrateLegacyState() (*State, error) {
+	cfg, err := ini.Load(legacyStatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := State{Schema: stateSchema, migratedFrom: legacyStatePath}
+
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+
+		var p Package
+		if err = section.MapTo(&p); err != nil {
+			return nil, err
+		}
+		p.Link = section.Name()
+		p.Packages = []string{section.Key("Package").String()}
+
+		s.Packages = append(s.Packages, p)
+	}
+
+	return &s, nil
+}
+
+// writes the state database atomically
+func (s *State) Save() error {
+	if err := os.MkdirAll("/var/lib/yadeb", 0755); err != nil {
+		return err
+	}
+
+	s.Schema = stateSchema
+
+	err := writeFileAtomic(statePath, 0644, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	// keep the old file around, but out of the way
+	if s.migratedFrom != "" {
+		if err := os.Rename(s.migratedFrom, s.migratedFrom+".migrated"); err != nil {
+			return err
+		}
+
+		s.migratedFrom = ""
+	}
+
+	return nil
+}
+
+// gets a tracked package by link, nil if it isn't tracked
+func (s *State) Get(link string) *Package {
+	for i := range s.Packages {
+		if s.Packages[i].Link == link {
+			return &s.Packages[i]
+		}
+	}
+
+	return nil
+}
+
+// adds or replaces a tracked package
+func (s *State) Put(p Package) {
+	if existing := s.Get(p.Link); existing != nil {
+		*existing = p
+		return
+	}
+
+	s.Packages = append(s.Packages, p)
+}
+
+// stops tracking a package. returns whether it was tracked.
+func (s *State) Remove(link string) bool {
+	n := len(s.Packages)
+	s.Packages = slices.DeleteFunc(s.Packages, func(p Package) bool {
+		return p.Link == link
+	})
+
+	return len(s.Packages) != n
+}
+
+// marks the packages in debFiles as installed from one link. p needs Link and InstalledTag, and whatever source keys apply.
+func markAsInstalled(debFiles []string, p Package) error {
+	var err error
+	if p.Packages, err = debPackageNames(debFiles); err != nil {
+		return err
+	}
+
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	p.InstallDate = time.Now().Format("2006-01-02")
+	p.LastUpdate = p.InstallDate
+
+	s.Put(p)
+	return s.Save()
+}
+
+// the debian package names of .deb files
+func debPackageNames(debFiles []string) ([]string, error) {
+	var names []string
+	for _, f := range debFiles {
+		out, err := exec.Command("dpkg-deb", "--field", f, "Package").Output()
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, strings.TrimSpace(string(out)))
+	}
+
+	return names, nil
+}
+
+// unmarks a package as installed
+func unmarkAsInstalled(link string) error {
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	if !s.Remove(link) {
+		return nil
+	}
+
+	return s.Save()
+}
+
+// updates a package's install mark after upgrading (or rolling back) it to p.
+// the debian packages it tracks are whatever p's files turned out to be.
+func updatePackageMark(p PackageToInstall) error {
+	debPkgs, err := debPackageNames(p.Paths)
+	if err != nil {
+		return err
+	}
+
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	mark := s.Get(p.Url.String())
+	if mark == nil {
+		return fmt.Errorf("%s isn't tracked", p.Url.String())
+	}
+
+	// remember what's being replaced. going back to a release takes it out of the history.
+	mark.History = append(mark.History, historyEntry{
+		Tag:         mark.InstalledTag,
+		InstallDate: mark.LastUpdate,
+		Assets:      mark.Assets,
+		Provenance:  mark.Provenance,
+	})
+	mark.History = slices.DeleteFunc(mark.History, func(h historyEntry) bool {
+		return h.Tag == p.Tag
+	})
+	mark.History = mark.History[max(0, len(mark.History)-maxHistory):]
+
+	mark.Packages = debPkgs
+	mark.InstalledTag = p.Tag
+	mark.LastUpdate = time.Now().Format("2006-01-02")
+	mark.Provenance = p.Provenance
+	mark.Distro = p.Distro
+	mark.Assets = p.Files
+
+	mark.LocalFile = p.Local || p.CacheOnly
+
+	return s.Save()
+}
+
+// gets a tracked package by link
+func getPackage(link string) (*Package, error) {
+	s, err := loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Get(link), nil
+}
+
+// gets all tracked packages
+func getAllPackages() ([]Package, error) {
+	s, err := loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Packages, nil
+}
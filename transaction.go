@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// what happened to each link of a command, shown at the end
+type runSummary struct {
+	names []string
+	errs  []error
+	notes []string // what was done, for the ones that worked
+}
+
+// records how a link went. returns its index, for filling in later with set.
+func (s *runSummary) add(name string, err error, note string) int {
+	s.names = append(s.names, name)
+	s.errs = append(s.errs, err)
+	s.notes = append(s.notes, note)
+
+	return len(s.names) - 1
+}
+
+// changes how a link went
+func (s *runSummary) set(i int, err error, note string) {
+	s.errs[i] = err
+	s.notes[i] = note
+}
+
+// prints the summary (if there's more than one link) and returns the exit code
+func (s *runSummary) print() int {
+	if len(s.names) > 1 {
+		fmt.Println("\nSummary:")
+		for i, name := range s.names {
+			if s.errs[i] != nil {
+				fmt.Printf("  \033[91m%s\033[0m: %s\n", name, s.errs[i])
+			} else {
+				fmt.Printf("  \033[92m%s\033[0m: %s\n", name, s.notes[i])
+			}
+		}
+	}
+
+	return s.exitCode()
+}
+
+// 0 if everything worked, exitPartial if only some of it did, otherwise what the first error calls for
+func (s *runSummary) exitCode() int {
+	failed := 0
+	for _, err := range s.errs {
+		if err != nil {
+			failed++
+		}
+	}
+
+	switch failed {
+	case 0:
+		return 0
+	case len(s.names):
+		for _, err := range s.errs {
+			if err != nil {
+				return exitCode(err)
+			}
+		}
+	}
+
+	return exitPartial
+}
+
+// downloads and verifies every package, then installs the ones that made it in a single apt transaction.
+// packages with a Mark are new and get marked as installed, the others get their marks updated.
+// returns an error (or nil) for each package.
+func installCandidates(cfg *ini.File, pkgs ...PackageToInstall) []error {
+	errs := make([]error, len(pkgs))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+
+		return errs
+	}
+
+	// create
+	tempDir, err := createTempDir()
+	if err != nil {
+		return fail(fmt.Errorf("couldn't create temp directory: %s", err))
+	}
+	defer cleanupDir(tempDir)
+
+	var (
+		paths []string
+		ready []int // downloaded, verified (and marked, for new ones)
+	)
+
+	prefetchDownloads(cfg, pkgs)
+
+	for i := range pkgs {
+		p := &pkgs[i]
+
+		// every package from a link gets installed, or none of them
+		fmt.Printf("Getting %s at release %s\n", p.Name, p.Tag)
+		var pkgPaths []string
+		for _, a := range p.Assets {
+			path, subject, err := fetchAsset(a, tempDir, p, cfg)
+			if err == nil {
+				err = p.addFile(a, path)
+			}
+
+			if err != nil {
+				errs[i] = fmt.Errorf("couldn't get %s: %s", a.Name, err)
+				ansiError(fmt.Sprintf("Not installing %s:", p.Name), errs[i].Error())
+				break
+			}
+
+			if subject != "" {
+				p.Provenance = subject
+			}
+
+			pkgPaths = append(pkgPaths, path)
+		}
+
+		if errs[i] != nil {
+			continue
+		}
+		p.Paths = pkgPaths
+
+		// new packages are marked before apt runs, and unmarked if it fails
+		if p.Mark != nil {
+			p.Mark.Provenance = p.Provenance
+			p.Mark.Assets = p.Files
+
+			fmt.Printf("Marking %s as installed...", p.Name)
+			if err := markAsInstalled(pkgPaths, *p.Mark); err != nil {
+				fmt.Println()
+				errs[i] = fmt.Errorf("couldn't mark %s as installed: %s", p.Name, err)
+				ansiError(errs[i].Error())
+				continue
+			}
+			fmt.Println(doneMsg)
+		}
+
+		paths = append(paths, pkgPaths...)
+		ready = append(ready, i)
+	}
+
+	if len(ready) == 0 {
+		return errs
+	}
+
+	// apt. upgrades were asked for already, new packages get apt's own prompt unless nobody's there to answer it.
+	var env []string
+	args := []string{"install"}
+	if nonInteractive {
+		args = append(args, "-y")
+		env = append(env, "DEBIAN_FRONTEND=noninteractive")
+	} else if !anyNew(pkgs, ready) {
+		args = append(args, "-y")
+	}
+
+	if slices.ContainsFunc(ready, func(i int) bool { return pkgs[i].Downgrade }) {
+		args = append(args, "--allow-downgrades")
+	}
+
+	fmt.Print("Starting APT (install)...\n\n")
+	if err := runApt(env, append(args, paths...)...); err != nil {
+		for _, i := range ready {
+			if pkgs[i].Mark == nil {
+				continue
+			}
+
+			// if apt fails then unmark the package
+			fmt.Printf("Removing installation mark for %s...", pkgs[i].Name)
+			if err := unmarkAsInstalled(pkgs[i].Url.String()); err != nil {
+				lnAnsiError(fmt.Sprintf("Couldn't remove installation mark for %s:", pkgs[i].Name), err.Error())
+				continue
+			}
+			fmt.Println(doneMsg)
+		}
+
+		return fail(fmt.Errorf("couldn't run apt: %s", err))
+	}
+
+	for _, i := range ready {
+		if pkgs[i].Mark != nil {
+			continue
+		}
+
+		// mark
+		fmt.Printf("Marking %s as updated...", pkgs[i].Name)
+		if err := updatePackageMark(pkgs[i]); err != nil {
+			errs[i] = fmt.Errorf("upgraded, but couldn't mark as updated: %s", err)
+			lnAnsiError(fmt.Sprintf("Couldn't mark %s as updated:", pkgs[i].Name), err.Error())
+			continue
+		}
+		fmt.Println(doneMsg)
+	}
+
+	pruneCache(cfg)
+
+	return errs
+}
+
+// records a fetched file as part of what p installs
+func (p *PackageToInstall) addFile(a Asset, path string) error {
+	sum, err := fileSha256(path)
+	if err != nil {
+		return err
+	}
+
+	p.Files = append(p.Files, installedAsset{Name: a.Name, Url: a.Url, Sha256: sum, Local: p.Local || p.CacheOnly})
+	return nil
+}
+
+// are any of pkgs[indexes] new installs?
+func anyNew(pkgs []PackageToInstall, indexes []int) bool {
+	for _, i := range indexes {
+		if pkgs[i].Mark != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// how a link is shown in messages: without https://
+func displayLink(link string) string {
+	return strings.TrimPrefix(link, "https://")
+}
@@ -2,18 +2,15 @@ package main
 
 import (
 	"fmt"
-	"net/url"
-	"path/filepath"
 	"slices"
 	"strings"
 	"syscall"
 
-	"github.com/tidwall/gjson"
 	"gopkg.in/ini.v1"
 )
 
 // the upgrade command
-func cmdUpgrade(links []string) int {
+func cmdUpgrade(links []string, opts RunOptions) int {
 	if len(links) == 0 {
 		ansiError("Nothing to upgrade")
 		return 2
@@ -24,270 +21,212 @@ func cmdUpgrade(links []string) int {
 		return 2
 	}
 
-	if err := createConfigDir(); err != nil {
-		ansiError("Couldn't create (or check existence of) /etc/yadeb")
+	lock, err := acquireLock()
+	if err != nil {
+		ansiError(err.Error())
 		return 1
 	}
+	defer releaseLock(lock)
 
-	cfg, err := ini.Load("/etc/yadeb/config.ini")
+	cfg, err := loadConfig()
 	if err != nil {
-		ansiError("Couldn't read /etc/yadeb/config.ini")
+		ansiError(err.Error())
 		return 1
 	}
 
-	// "common hack"
-	raw := links[0]
-	if !strings.Contains(raw, "://") {
-		raw = "https://" + raw
+	return upgradeLinks(links, opts, cfg)
+}
+
+// the upgrade-all command
+func cmdUpgradeAll(opts RunOptions) int {
+	if syscall.Geteuid() != 0 {
+		ansiError("Upgrading requires root privileges")
+		return 2
 	}
 
-	// parse link
-	u, err := url.Parse(raw)
+	lock, err := acquireLock()
 	if err != nil {
-		ansiError("Couldn't parse link:", err.Error())
+		ansiError(err.Error())
 		return 1
 	}
+	defer releaseLock(lock)
 
-	// error out if unknown scheme
-	if !slices.Contains([]string{"https", ""}, u.Scheme) {
-		ansiError("Unknown source scheme:", u.Scheme)
-		return 2
+	cfg, err := loadConfig()
+	if err != nil {
+		ansiError(err.Error())
+		return 1
 	}
 
-	// get existing package
-	p, err := getPackage(u.String())
+	pkgs, err := getAllPackages()
 	if err != nil {
-		ansiError("Couldn't read installed package database:", err.Error())
+		ansiError(err.Error())
 		return 1
 	}
-	if p == nil {
-		ansiError("Requested package isn't installed")
-		return 1
+
+	var links []string
+	for _, p := range pkgs {
+		links = append(links, p.Link)
 	}
 
-	// init architecture slice
-	for _, v := range architectureAliases {
-		allArchitectures = append(allArchitectures, v...)
+	if len(links) == 0 {
+		return 0
 	}
 
+	return upgradeLinks(links, opts, cfg)
+}
+
+// upgrades links: everything is resolved, then downloaded, then installed in one apt transaction
+func upgradeLinks(links []string, opts RunOptions, cfg *ini.File) int {
 	var (
-		candidates []string
-		pkgName    string
-		tag        string
+		summary runSummary
+		pkgs    []PackageToInstall
+		indexes []int // pkgs' places in summary
 	)
 
-	// decide what to do based on domain
-	switch u.Host {
-	case "github.com":
-		// get user and repo
-		pkgName, _ := strings.CutPrefix(u.Path, "/")
+	prefetchReleases(links, cfg)
 
-		// get releases
-		fmt.Printf("Checking github.com/%s...", pkgName)
-		releaseJson, err := githubGetReleases(pkgName, cfg.Section("yadeb").Key("ReleaseDepth").MustInt(50))
+	for _, link := range links {
+		pii, err := resolveUpgrade(link, cfg)
 		if err != nil {
-			lnAnsiError("couldn't get github releases:", err.Error())
-			return 1
+			ansiError(err.Error())
+			summary.add(displayLink(link), err, "")
+			continue
 		}
 
-		if gjson.Get(releaseJson, "#").Int() == 0 {
-			lnAnsiError("requested package has no releases available")
-			return 1
+		if pii == nil {
+			summary.add(displayLink(link), nil, "already at latest")
+			continue
 		}
 
-		tag, candidates, err = githubFindLatestValidRelease(releaseJson, cfg)
-		if err != nil {
-			lnAnsiError(err.Error())
-			return 1
-		}
-	default:
-		ansiError("Unknown source domain:", u.Host)
-		return 2
+		indexes = append(indexes, summary.add(displayLink(pii.Url.String()), nil, ""))
+		pkgs = append(pkgs, *pii)
 	}
 
-	if p.InstalledTag == tag {
-		fmt.Printf(" \033[92mAlready at latest (%s)\033[0m\n", tag)
-		return 0
+	if opts.DryRun || opts.Simulate {
+		return showPlan(cfg, opts, &summary, pkgs, indexes)
 	}
 
-	fmt.Printf(" \033[92mNew version available (%s)\033[0m\n", tag)
-
-	if len(candidates) != 1 {
-		installUserChoice(candidates)
+	if len(pkgs) != 0 {
+		for i, err := range installCandidates(cfg, pkgs...) {
+			summary.set(indexes[i], err, "upgraded to "+pkgs[i].Tag)
+		}
 	}
 
-	pii := PackageToInstall{
-		Name:         pkgName,
-		Tag:          tag,
-		DownloadLink: candidates[0],
-		Url:          u,
-	}
+	return summary.print()
+}
 
-	if len(candidates) != 1 {
-		installUserChoice(candidates)
+// finds what to upgrade a link to. nil, nil if it's already at the latest release.
+func resolveUpgrade(link string, cfg *ini.File) (*PackageToInstall, error) {
+	u, src, err := parseLink(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link: %s", err)
 	}
 
-	// downlad the remaining candidate
-	if err := candidateUpgrade(pii); err != nil {
-		ansiError(fmt.Sprintf("Couldn't upgrade %s: %s", pkgName, err.Error()))
-		return 1
+	// get existing package
+	p, err := getPackage(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read installed package database: %s", err)
 	}
-
-	return 0
-}
-
-// the upgrade command
-func cmdUpgradeAll() int {
-	if syscall.Geteuid() != 0 {
-		ansiError("Upgrading requires root privileges")
-		return 2
+	if p == nil {
+		return nil, fmt.Errorf("%s isn't installed", displayLink(u.String()))
 	}
 
-	if err := createConfigDir(); err != nil {
-		ansiError("Couldn't create (or check existence of) /etc/yadeb")
-		return 1
+	if p.VersionUrl != "" {
+		setVersionRule(p.Link, versionRule{p.VersionUrl, p.VersionPath, p.VersionRegex})
 	}
 
-	cfg, err := ini.Load("/etc/yadeb/config.ini")
+	rel, candidates, err := resolveRelease(src, u, "latest", packageArch(p), cfg)
 	if err != nil {
-		ansiError("Couldn't read /etc/yadeb/config.ini")
-		return 1
+		return nil, err
 	}
 
-	// init architecture slice
-	for _, v := range architectureAliases {
-		allArchitectures = append(allArchitectures, v...)
+	if p.InstalledTag == rel.Tag {
+		fmt.Printf("\033[92mAlready at latest (%s)\033[0m\n", rel.Tag)
+		return nil, nil
 	}
 
-	var pii []PackageToInstall
+	fmt.Printf("\033[92mNew version available (%s)\033[0m\n", rel.Tag)
 
-	pkgs, err := getAllPackages()
+	assets, err := upgradeAssets(rel, candidates, p, cfg)
 	if err != nil {
-		ansiError(err.Error())
+		return nil, err
 	}
 
-	if pkgs == nil {
-		return 0
+	if err := prepareAssets(rel, assets, u.String(), sigstoreIdentity{p.SigstoreIssuer, p.SigstoreSubject}, cfg); err != nil {
+		return nil, err
 	}
 
-	for _, p := range pkgs {
-		if p.Link == "DEFAULT" {
-			continue
-		}
+	return &PackageToInstall{
+		Name:    src.PackageName(u),
+		Tag:     rel.Tag,
+		Assets:  assets,
+		Distro:  assetsDistro(assets),
+		FromTag: p.InstalledTag,
+		Url:     u,
+	}, nil
+}
 
-		// parse link
-		u, err := url.Parse(p.Link)
+// picks what to download to upgrade p: the asset matching its pattern, or the files of its tracked packages
+// (for its distro variant), or whatever the user chooses
+func upgradeAssets(rel *Release, candidates []Asset, p *Package, cfg *ini.File) ([]Asset, error) {
+	if p.AssetPattern != "" {
+		a, err := matchAssetPattern(rel, p.AssetPattern)
 		if err != nil {
-			ansiError("Couldn't parse link:", err.Error())
-			return 1
-		}
-
-		var (
-			candidates []string
-			pkgName    string
-			tag        string
-		)
-
-		// decide what to do based on domain
-		switch u.Host {
-		case "github.com":
-			// get user and repo
-			pkgName, _ = strings.CutPrefix(u.Path, "/")
-
-			// get releases
-			fmt.Printf("Checking github.com/%s...", pkgName)
-			releaseJson, err := githubGetReleases(pkgName, cfg.Section("yadeb").Key("ReleaseDepth").MustInt(50))
-			if err != nil {
-				lnAnsiError("couldn't get github releases:", err.Error())
-				return 1
-			}
-
-			if gjson.Get(releaseJson, "#").Int() == 0 {
-				lnAnsiError("requested package has no releases available")
-				continue
-			}
-
-			tag, candidates, err = githubFindLatestValidRelease(releaseJson, cfg)
-			if err != nil {
-				lnAnsiError(err.Error())
-				return 1
-			}
-		default:
-			ansiError("Unknown source domain:", u.Host)
-			return 2
-		}
-
-		if p.InstalledTag == tag {
-			fmt.Printf(" \033[92mAlready at latest (%s)\033[0m\n", tag)
-			continue
+			return nil, err
 		}
 
-		fmt.Printf(" \033[92mNew version available (%s)\033[0m\n", tag)
+		return []Asset{a}, nil
+	}
 
-		if len(candidates) != 1 {
-			installUserChoice(candidates)
-		}
+	candidates = filterDistro(candidates, p.Distro)
 
-		pii = append(pii, PackageToInstall{
-			Name:         pkgName,
-			Tag:          tag,
-			DownloadLink: candidates[0],
-			Url:          u,
-		})
+	if assets := trackedAssets(candidates, p.Packages); assets != nil {
+		return assets, nil
 	}
 
-	if len(pii) == 0 {
-		return 0
+	// like when foo-dbgsym stopped being released. whatever gets picked is what the link tracks from now on.
+	if len(p.Packages) > 1 {
+		fmt.Printf("\033[93mRelease %s doesn't have files for all of %s\033[0m\n", rel.Tag, strings.Join(p.Packages, ", "))
+		return chooseAssets(candidates, cfg)
 	}
 
-	// downlad the remaining candidates
-	if err := candidateUpgrade(pii...); err != nil {
-		ansiError(fmt.Sprintf("Couldn't upgrade everything: %s", err.Error()))
-		return 1
+	a, err := chooseAsset(candidates, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	return 0
+	return []Asset{a}, nil
 }
 
-// upgrades candidates
-func candidateUpgrade(pkgs ...PackageToInstall) error {
-	// create
-	tempDir, err := createTempDir()
-	if err != nil {
-		return fmt.Errorf("couldn't create temp directory: %s", err)
-	}
-
-	var paths []string
+// picks the candidates that provide a tracked link's debian packages, by their control files
+// or their foo_1.0_amd64.deb file names.
+// nil if any of them can't be found, like when the files aren't named after the package.
+func trackedAssets(candidates []Asset, debPkgs []string) []Asset {
+	var assets []Asset
+	for _, name := range debPkgs {
+		i := slices.IndexFunc(candidates, func(a Asset) bool {
+			if a.Control != nil {
+				return a.Control.Package == name
+			}
 
-	for _, p := range pkgs {
-		path := fmt.Sprintf("%s/%s", tempDir, filepath.Base(p.DownloadLink))
+			return strings.HasPrefix(a.Name, name+"_")
+		})
 
-		// download
-		fmt.Printf("Downloading %s from %s at tag %s...", filepath.Base(p.DownloadLink), p.Name, p.Tag)
-		if err := downloadFile(p.DownloadLink, path); err != nil {
-			lnAnsiError(fmt.Sprintf("Couldn't download %s:", p.Name), err.Error())
-			continue
+		if i < 0 {
+			return nil
 		}
-		fmt.Println(doneMsg)
 
-		paths = append(paths, path)
+		assets = append(assets, candidates[i])
 	}
 
-	// apt
-	fmt.Print("Starting APT (install)...\n\n")
-	if err := runApt(append([]string{"install", "-y"}, paths...)...); err != nil {
-		ansiError("Couldn't run apt: %s", err.Error())
-		return cleanupDir(tempDir)
-	}
+	return assets
+}
 
-	for _, p := range pkgs {
-		// mark
-		fmt.Printf("Marking %s as updated...", p.Name)
-		if err := updatePackageMark(p.Url.String(), p.Tag); err != nil {
-			ansiError(fmt.Sprintf("Couldn't mark %s as updated:", p.Name), err.Error())
-		}
-		fmt.Println(doneMsg)
+// the architecture a package was installed for
+func packageArch(p *Package) string {
+	if p.Architecture != "" {
+		return p.Architecture
 	}
 
-	return cleanupDir(tempDir)
+	return debianArch()
 }
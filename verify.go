@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"gopkg.in/ini.v1"
+)
+
+// finds checksums, signatures and sigstore bundles in rel for each of assets
+func prepareAssets(rel *Release, assets []Asset, link string, id sigstoreIdentity, cfg *ini.File) error {
+	for i := range assets {
+		if err := findChecksum(rel, &assets[i], cfg); err != nil {
+			return err
+		}
+
+		if err := findSignature(rel, &assets[i], link); err != nil {
+			return err
+		}
+
+		if err := findSigstore(rel, &assets[i], id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downloads (or copies, if local or cached) one of p's assets into dir and checks whatever prepareAssets found for it.
+// returns the path and the verified sigstore subject, if any.
+func fetchAsset(a Asset, dir string, p *PackageToInstall, cfg *ini.File) (string, string, error) {
+	path := filepath.Join(dir, filepath.Base(a.Url))
+
+	// local files can change under the same name and tag, so they're only ever written to the cache
+	var cached string
+	if !p.Local {
+		cached = cachedAsset(a, p.Tag, cfg)
+	}
+
+	if cached == "" && p.CacheOnly {
+		return "", "", fmt.Errorf("%s was a local file, and isn't in the cache anymore", a.Name)
+	}
+
+	var err error
+	if cached != "" {
+		fmt.Printf("Copying %s from the cache...", a.Name)
+		err = copyFile(cached, path)
+	} else if p.Local {
+		fmt.Printf("Copying %s...", filepath.Base(a.Url))
+		err = copyFile(a.Url, path)
+	} else {
+		fmt.Printf("Downloading %s...", a.Name)
+		err = downloadFile(a.Url, path, a.Checksum)
+	}
+
+	if err != nil {
+		fmt.Println()
+		return "", "", err
+	}
+
+	// downloadFile and cachedAsset already checked, local copies didn't
+	if p.Local && a.Checksum != "" {
+		if got, err := fileSha256(path); err != nil || got != a.Checksum {
+			fmt.Println()
+			return "", "", fmt.Errorf("checksum mismatch: expected %s, got %s", a.Checksum, got)
+		}
+	}
+	fmt.Println(doneMsg)
+
+	if a.Signature != "" {
+		if err := verifyFileSignature(path, a.Signature, p.Url.String()); err != nil {
+			return "", "", err
+		}
+	}
+
+	var subject string
+	if a.Cosign != nil {
+		if subject, err = verifySigstore(path, a.Cosign, cfg); err != nil {
+			return "", "", err
+		}
+	}
+
+	// only verified files get cached
+	if cached == "" {
+		cacheAsset(path, a, p.Tag, cfg)
+	}
+
+	return path, subject, nil
+}
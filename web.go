@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/ini.v1"
+)
+
+type (
+	// how to find the versions of a url template
+	versionRule struct {
+		Url   string // page or api endpoint listing versions
+		Path  string // gjson path to the version(s), if Url returns json
+		Regex string // regex whose first group is a version, if Url returns anything else
+	}
+
+	// plain https links for projects without a forge: direct .deb links and url templates like
+	// https://example.com/dl/tool_{version}_{arch}.deb
+	webSource struct {
+		rules map[string]versionRule // keyed by normalized link
+	}
+)
+
+var webSourceInstance = &webSource{rules: map[string]versionRule{}}
+
+func init() {
+	// anything https that isn't a known forge ends up here
+	registerSource(webSourceInstance, "https")
+}
+
+// sets the version discovery rule of a url template
+func setVersionRule(link string, r versionRule) {
+	webSourceInstance.rules[link] = r
+}
+
+func (s *webSource) Configure(cfg *ini.File) error {
+	return nil
+}
+
+// direct .deb links and templates are kept as they are
+func (s *webSource) Normalize(u *url.URL) (*url.URL, error) {
+	if !isUrlTemplate(u) && !strings.HasSuffix(u.Path, ".deb") {
+		return nil, fmt.Errorf("unknown source domain: %s (links to other hosts must be a .deb file or a url template containing {version})", u.Host)
+	}
+
+	return &url.URL{Scheme: "https", Host: u.Host, Path: u.Path, RawQuery: u.RawQuery}, nil
+}
+
+// the file name (template)
+func (s *webSource) PackageName(u *url.URL) string {
+	return path.Base(u.Path)
+}
+
+// templates: every version the rule finds, newest first. direct links: the file as it is right now.
+func (s *webSource) Releases(u *url.URL, depth int) ([]Release, error) {
+	if !isUrlTemplate(u) {
+		rel, err := s.directRelease(u)
+		if err != nil {
+			return nil, err
+		}
+
+		return []Release{*rel}, nil
+	}
+
+	rule, ok := s.rules[u.String()]
+	if !ok || rule.Url == "" {
+		return nil, fmt.Errorf("url template has no version rule (use --version-url)")
+	}
+
+	versions, err := discoverVersions(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []Release
+	for _, v := range versions[:min(len(versions), depth)] {
+		releases = append(releases, templateRelease(u, v))
+	}
+
+	return releases, nil
+}
+
+// templates can be expanded with any tag. direct links have no tags.
+func (s *webSource) ReleaseByTag(u *url.URL, tag string) (*Release, error) {
+	if !isUrlTemplate(u) {
+		return nil, fmt.Errorf("direct links don't have releases to pick from")
+	}
+
+	rel := templateRelease(u, tag)
+	return &rel, nil
+}
+
+func (s *webSource) Authorize(req *http.Request) {}
+
+// a direct link as a release. the tag is when the file last changed, so upgrades notice new uploads.
+func (s *webSource) directRelease(u *url.URL) (*Release, error) {
+	resp, err := http.Head(u.String())
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &apiStatusError{StatusCode: resp.StatusCode}
+	}
+
+	// final url, after redirects like /latest -> /1.2.3/
+	final := resp.Request.URL
+	tag := path.Base(final.Path)
+
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		tag = t.UTC().Format(time.RFC3339)
+	} else if etag := resp.Header.Get("ETag"); etag != "" {
+		tag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	}
+
+	return &Release{
+		Tag:    tag,
+		Assets: []Asset{{Name: path.Base(final.Path), Url: u.String(), Size: max(resp.ContentLength, 0)}},
+	}, nil
+}
+
+// does the link contain {version}?
+func isUrlTemplate(u *url.URL) bool {
+	return strings.Contains(u.Path, "{version}") || strings.Contains(u.Path, "{tag}")
+}
+
+// fills in a url template. {tag} is the version as found, {version} is without a leading v.
+// {arch} is left for releaseCandidates, which knows what architecture is wanted.
+func templateRelease(u *url.URL, tag string) Release {
+	link := strings.NewReplacer(
+		"{tag}", tag,
+		"{version}", strings.TrimPrefix(tag, "v"),
+	).Replace(u.Scheme + "://" + u.Host + u.Path)
+
+	if u.RawQuery != "" {
+		link += "?" + u.RawQuery
+	}
+
+	return Release{
+		Tag:    tag,
+		Assets: []Asset{{Name: path.Base(link), Url: link}},
+	}
+}
+
+// runs a version rule, returning unique versions, newest first
+func discoverVersions(rule versionRule) ([]string, error) {
+	req, err := http.NewRequest("GET", rule.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &apiStatusError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+
+	switch {
+	case rule.Path != "":
+		res := gjson.GetBytes(body, rule.Path)
+		if !res.Exists() {
+			return nil, fmt.Errorf("%s has nothing at %s", rule.Url, rule.Path)
+		}
+
+		if res.IsArray() {
+			for _, v := range res.Array() {
+				versions = append(versions, v.String())
+			}
+		} else {
+			versions = append(versions, res.String())
+		}
+	case rule.Regex != "":
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("bad version regex: %s", err)
+		}
+
+		if re.NumSubexp() < 1 {
+			return nil, fmt.Errorf("version regex needs a capture group")
+		}
+
+		for _, m := range re.FindAllSubmatch(body, -1) {
+			versions = append(versions, string(m[1]))
+		}
+	default:
+		return nil, fmt.Errorf("version rule needs a path or a regex")
+	}
+
+	versions = slices.DeleteFunc(versions, func(v string) bool {
+		return v == ""
+	})
+
+	slices.SortFunc(versions, func(a, b string) int {
+		return compareVersions(b, a)
+	})
+
+	return slices.Compact(versions), nil
+}